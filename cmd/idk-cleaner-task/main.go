@@ -2,18 +2,37 @@ package main
 
 import (
 	"context"
+	"time"
 
 	"github.com/klwxsrx/go-service-template/internal/pkg/cmd"
 	pkgcmd "github.com/klwxsrx/go-service-template/pkg/cmd"
+	"github.com/klwxsrx/go-service-template/pkg/worker"
 )
 
+// messageStoragePollingInterval is the fallback for lost notifications and messages scheduled in the future
+const messageStoragePollingInterval = 5 * time.Second
+
 func main() {
 	ctx := context.Background()
 	infra := cmd.NewInfrastructureContainer(ctx)
 	defer infra.Close(ctx)
 
-	pkgcmd.MustRun(ctx, infra.Logger.MustLoad(),
-		pkgcmd.TermSignalAwaiter,
-		infra.IdempotencyKeysCleaner.MustLoad().DeleteOutdated,
+	recurringTasks := infra.RecurringTaskScheduler.MustLoad()
+	messageBus := infra.MessageBusListener.MustLoad()
+	cmd.MustRegisterIDKCleanup(
+		infra.TaskScheduler.MustLoad(),
+		recurringTasks,
+		messageBus,
+		infra.IdempotencyKeysCleaner.MustLoad(),
 	)
+
+	messageStorageConsumers := infra.MessageStorageConsumers.MustLoad()
+	messageHandlerWorkers := append(messageStorageConsumers.Workers(), messageBus.Workers()...)
+	pkgcmd.MustRun(ctx, infra.Logger.MustLoad(), append(
+		messageHandlerWorkers,
+		pkgcmd.TermSignalAwaiter,
+		recurringTasks.Worker,
+		infra.MessageStorageListener.MustLoad(),
+		worker.PeriodicalJob(messageStorageConsumers.Process, messageStoragePollingInterval),
+	)...)
 }
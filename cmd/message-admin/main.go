@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/klwxsrx/go-service-template/internal/pkg/cmd"
+	"github.com/klwxsrx/go-service-template/internal/userprofile"
+	"github.com/klwxsrx/go-service-template/pkg/message"
+)
+
+const usage = `usage: message-admin <command> [flags]
+
+commands:
+  list     lists stored messages: -topic, -subscriber, -failed, -older-than, -limit
+  requeue  schedules the immediate delivery of messages: -id, optional -topic
+  move     moves messages to another topic: -topic, -to, -id
+  purge    deletes messages: -topic, -id
+`
+
+type (
+	listedMessage struct {
+		ID            uuid.UUID                 `json:"id"`
+		Topic         message.Topic             `json:"topic"`
+		Key           string                    `json:"key,omitempty"`
+		ScheduledAt   time.Time                 `json:"scheduledAt"`
+		Attempts      int                       `json:"attempts"`
+		LastError     *string                   `json:"lastError,omitempty"`
+		FirstFailedAt *time.Time                `json:"firstFailedAt,omitempty"`
+		Type          string                    `json:"type,omitempty"`
+		Headers       map[string]string         `json:"headers,omitempty"`
+		Metadata      message.Metadata          `json:"metadata,omitempty"`
+		Payload       message.StructuredMessage `json:"payload,omitempty"`
+		RawPayload    []byte                    `json:"rawPayload,omitempty"`
+		DecodeError   string                    `json:"decodeError,omitempty"`
+	}
+
+	idsFlag []uuid.UUID
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	os.Exit(execute(os.Args[1], os.Args[2:]))
+}
+
+func execute(command string, args []string) int {
+	ctx := context.Background()
+	infra := cmd.NewInfrastructureContainer(ctx)
+	defer infra.Close(ctx)
+
+	userProfile := userprofile.NewDependencyContainer(
+		infra.DB,
+		infra.DBMigrations,
+		infra.HTTPClientFactory,
+		infra.IdempotencyKeys,
+	)
+
+	admin := infra.MessageStorageAdmin.MustLoad()
+	userProfile.MustRegisterMessageHandlers(admin)
+
+	err := run(ctx, admin, command, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", command, err)
+		return 1
+	}
+
+	return 0
+}
+
+func run(ctx context.Context, admin *message.StorageAdmin, command string, args []string) error {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	topic := flags.String("topic", "", "topic of the messages, comma-separated list for the list command")
+	subscriber := flags.String("subscriber", "", "show the delivery state of the subscriber")
+	failedOnly := flags.Bool("failed", false, "list messages having failed delivery attempts only")
+	olderThan := flags.Duration("older-than", 0, "list messages scheduled earlier than the duration ago")
+	limit := flags.Int("limit", 100, "max number of the listed messages")
+	targetTopic := flags.String("to", "", "target topic of the moved messages")
+	var ids idsFlag
+	flags.Var(&ids, "id", "message ID, could be specified multiple times")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "list":
+		return list(ctx, admin, message.StorageAdminFilter{
+			Topics:     parseTopics(*topic),
+			Subscriber: message.Subscriber(*subscriber),
+			FailedOnly: *failedOnly,
+			OlderThan:  *olderThan,
+			Limit:      *limit,
+		})
+	case "requeue":
+		if len(ids) == 0 {
+			return errors.New("-id is required")
+		}
+
+		return admin.Requeue(ctx, message.Topic(*topic), ids...)
+	case "move":
+		if *topic == "" || *targetTopic == "" || len(ids) == 0 {
+			return errors.New("-topic, -to and -id are required")
+		}
+
+		return admin.Move(ctx, message.Topic(*topic), message.Topic(*targetTopic), ids...)
+	case "purge":
+		if *topic == "" || len(ids) == 0 {
+			return errors.New("-topic and -id are required")
+		}
+
+		return admin.Purge(ctx, message.Topic(*topic), ids...)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return flag.ErrHelp
+	}
+}
+
+func list(ctx context.Context, admin *message.StorageAdmin, filter message.StorageAdminFilter) error {
+	msgs, err := admin.List(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	for _, msg := range msgs {
+		listed := listedMessage{
+			ID:            msg.ID,
+			Topic:         msg.Topic,
+			Key:           msg.Key,
+			ScheduledAt:   msg.ScheduledAt,
+			Attempts:      msg.Attempts,
+			LastError:     msg.LastError,
+			FirstFailedAt: msg.FirstFailedAt,
+			Type:          "",
+			Headers:       msg.Headers,
+			Metadata:      msg.Metadata,
+			Payload:       msg.Decoded,
+			RawPayload:    nil,
+			DecodeError:   "",
+		}
+		if msg.Decoded != nil {
+			listed.Type = msg.Decoded.Type()
+		}
+		if msg.DecodeError != nil {
+			listed.RawPayload = msg.Payload
+			listed.DecodeError = msg.DecodeError.Error()
+		}
+
+		err = encoder.Encode(listed)
+		if err != nil {
+			return fmt.Errorf("encode message %v: %w", msg.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func parseTopics(value string) []message.Topic {
+	if value == "" {
+		return nil
+	}
+
+	var topics []message.Topic
+	for _, topic := range strings.Split(value, ",") {
+		topics = append(topics, message.Topic(strings.TrimSpace(topic)))
+	}
+
+	return topics
+}
+
+func (f *idsFlag) String() string {
+	ids := make([]string, 0, len(*f))
+	for _, id := range *f {
+		ids = append(ids, id.String())
+	}
+
+	return strings.Join(ids, ",")
+}
+
+func (f *idsFlag) Set(value string) error {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return fmt.Errorf("parse message id: %w", err)
+	}
+
+	*f = append(*f, id)
+	return nil
+}
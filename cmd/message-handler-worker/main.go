@@ -10,6 +10,12 @@ import (
 	"github.com/klwxsrx/go-service-template/pkg/worker"
 )
 
+const (
+	// messageStoragePollingInterval is the fallback for lost notifications and messages scheduled in the future
+	messageStoragePollingInterval = 5 * time.Second
+	messageStorageMetricsInterval = 15 * time.Second
+)
+
 func main() {
 	ctx := context.Background()
 	infra := cmd.NewInfrastructureContainer(ctx)
@@ -25,11 +31,14 @@ func main() {
 	messageBus := infra.MessageBusListener.MustLoad()
 	userProfile.MustRegisterMessageHandlers(messageBus)
 
+	logger := infra.Logger.MustLoad()
 	messageStorageConsumers := infra.MessageStorageConsumers.MustLoad()
 	messageHandlerWorkers := append(messageStorageConsumers.Workers(), messageBus.Workers()...)
-	pkgcmd.MustRun(ctx, infra.Logger.MustLoad(), append(
+	pkgcmd.MustRun(ctx, logger, append(
 		messageHandlerWorkers,
 		pkgcmd.TermSignalAwaiter,
-		worker.PeriodicalJob(messageStorageConsumers.Process, time.Second),
+		infra.MessageStorageListener.MustLoad(),
+		worker.PeriodicalJob(messageStorageConsumers.Process, messageStoragePollingInterval),
+		worker.PeriodicalContextJob(infra.MessageStorageMetrics.MustLoad().Collect, messageStorageMetricsInterval, logger),
 	)...)
 }
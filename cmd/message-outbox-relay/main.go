@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+
+	"github.com/klwxsrx/go-service-template/internal/pkg/cmd"
+	pkgcmd "github.com/klwxsrx/go-service-template/pkg/cmd"
+)
+
+func main() {
+	ctx := context.Background()
+	infra := cmd.NewInfrastructureContainer(ctx)
+	defer infra.Close(ctx)
+
+	outboxRelayWorkers := infra.MessageOutboxRelay.MustLoad().Workers(infra.NATSBroker.MustLoad())
+	pkgcmd.MustRun(ctx, infra.Logger.MustLoad(), append(
+		outboxRelayWorkers,
+		pkgcmd.TermSignalAwaiter,
+	)...)
+}
@@ -7,19 +7,31 @@ import (
 	"runtime/debug"
 	"time"
 
+	"github.com/cenkalti/backoff/v4"
+
 	internalauth "github.com/klwxsrx/go-service-template/internal/pkg/auth"
 	"github.com/klwxsrx/go-service-template/internal/pkg/http"
 	pkgauth "github.com/klwxsrx/go-service-template/pkg/auth"
 	"github.com/klwxsrx/go-service-template/pkg/env"
+	"github.com/klwxsrx/go-service-template/pkg/eventstore"
 	pkghttp "github.com/klwxsrx/go-service-template/pkg/http"
 	"github.com/klwxsrx/go-service-template/pkg/idk"
 	"github.com/klwxsrx/go-service-template/pkg/lazy"
 	"github.com/klwxsrx/go-service-template/pkg/log"
 	"github.com/klwxsrx/go-service-template/pkg/message"
 	"github.com/klwxsrx/go-service-template/pkg/metric"
+	pkgnats "github.com/klwxsrx/go-service-template/pkg/nats"
 	"github.com/klwxsrx/go-service-template/pkg/observability"
 	"github.com/klwxsrx/go-service-template/pkg/sql"
 	pkgtime "github.com/klwxsrx/go-service-template/pkg/time"
+	"github.com/klwxsrx/go-service-template/pkg/worker"
+)
+
+const (
+	// messageHandlerInProcessRetries is the number of handler retries before the message is rescheduled in the storage
+	messageHandlerInProcessRetries = 3
+	messageHandlerTimeout          = 30 * time.Second
+	messageHandlerDrainTimeout     = 25 * time.Second
 )
 
 var logLevelMap = map[string]log.Level{
@@ -35,8 +47,15 @@ type InfrastructureContainer struct {
 	HTTPClientFactory       lazy.Loader[HTTPClientFactory]
 	EventDispatcher         lazy.Loader[message.EventDispatcher]
 	TaskScheduler           lazy.Loader[message.TaskScheduler]
+	RecurringTaskScheduler  lazy.Loader[message.RecurringTaskScheduler]
 	MessageBusListener      lazy.Loader[message.BusListener]
 	MessageStorageConsumers lazy.Loader[message.StorageConsumerProvider]
+	MessageStorageListener  lazy.Loader[worker.ContextJob]
+	MessageOutboxRelay      lazy.Loader[MessageOutboxRelay]
+	MessageStorageAdmin     lazy.Loader[*message.StorageAdmin]
+	MessageStorageMetrics   lazy.Loader[*message.StorageMetricsCollector]
+	NATSBroker              lazy.Loader[*pkgnats.Broker]
+	EventStoreStorage       lazy.Loader[eventstore.Storage]
 	IdempotencyKeys         lazy.Loader[idk.Service]
 	IdempotencyKeysCleaner  lazy.Loader[idk.Cleaner]
 	DBMigrations            lazy.Loader[SQLMigrations]
@@ -54,28 +73,39 @@ func NewInfrastructureContainer(ctx context.Context) *InfrastructureContainer {
 	auth := authProvider()
 	clock := clockProvider()
 
-	db := sqlDatabaseProvider(ctx)
+	sqlConfig := sqlConfigProvider()
+	db := sqlDatabaseProvider(ctx, sqlConfig)
 	dbMigrations := sqlMigrationsProvider(ctx, db, logger)
 	msgStorage := sqlMessageStorageProvider(db, dbMigrations)
 	idkStorage := sqlIDKStorageProvider(db, dbMigrations)
+	recurringTaskStorage := sqlRecurringTaskStorageProvider(db, dbMigrations)
 
+	msgSchemaRegistry := lazy.New(func() (*message.SchemaRegistry, error) { return message.NewSchemaRegistry(), nil })
 	msgStorageConsumerProvider := messageStorageConsumerProvider(msgStorage, metrics, logger)
-	consumerProvider := lazy.New(func() (message.ConsumerProvider[message.AckStrategy], error) {
+	consumerProvider := lazy.New(func() (message.ConsumerProvider[message.AckNackStrategy], error) {
 		return msgStorageConsumerProvider.MustLoad(), nil
 	})
 
-	msgBusProducer := messageBusProducerProvider(msgStorage, observer, metrics, logger)
+	msgBusProducer := messageBusProducerProvider(msgStorage, msgSchemaRegistry, observer, metrics, logger)
 	idkServiceImpl := idkServiceProvider(idkStorage)
 	idkService := lazy.New(func() (idk.Service, error) { return idkServiceImpl.Load() })
 	idkCleaner := lazy.New(func() (idk.Cleaner, error) { return idkServiceImpl.Load() })
+	taskScheduler := taskSchedulerProvider(msgBusProducer, msgStorage)
 
 	return &InfrastructureContainer{
 		HTTPServer:              httpServerProvider(observer, metrics, logger, auth),
 		HTTPClientFactory:       httpClientFactoryProvider(observer, metrics, logger),
 		EventDispatcher:         eventDispatcherProvider(msgBusProducer),
-		TaskScheduler:           taskSchedulerProvider(msgBusProducer),
-		MessageBusListener:      messageBusListenerProvider(consumerProvider, observer, metrics, logger),
+		TaskScheduler:           taskScheduler,
+		RecurringTaskScheduler:  recurringTaskSchedulerProvider(taskScheduler, recurringTaskStorage, db, metrics, logger),
+		MessageBusListener:      messageBusListenerProvider(consumerProvider, msgSchemaRegistry, observer, metrics, logger),
 		MessageStorageConsumers: msgStorageConsumerProvider,
+		MessageStorageListener:  messageStorageListenerProvider(sqlConfig, msgStorageConsumerProvider, logger),
+		MessageOutboxRelay:      messageOutboxRelayProvider(sqlConfig, msgStorage, metrics, logger),
+		MessageStorageAdmin:     messageStorageAdminProvider(msgStorage, msgSchemaRegistry, db),
+		MessageStorageMetrics:   messageStorageMetricsProvider(msgStorage, metrics),
+		NATSBroker:              natsBrokerProvider(ctx, logger),
+		EventStoreStorage:       sqlEventStoreStorageProvider(db, dbMigrations),
 		IdempotencyKeys:         idkService,
 		IdempotencyKeysCleaner:  idkCleaner,
 		DBMigrations:            dbMigrations,
@@ -97,6 +127,12 @@ func (i *InfrastructureContainer) Close(ctx context.Context) {
 		defer os.Exit(1)
 	}
 
+	i.NATSBroker.IfLoaded(func(broker *pkgnats.Broker) {
+		if err := broker.Close(); err != nil {
+			i.Logger.MustLoad().WithError(err).Error(ctx, "failed to close nats broker")
+		}
+	})
+
 	i.DB.IfLoaded(func(db sql.Database) {
 		if err := db.Close(); err != nil {
 			i.Logger.MustLoad().WithError(err).Error(ctx, "failed to close postgresql database")
@@ -148,8 +184,8 @@ func clockProvider() lazy.Loader[pkgtime.Clock] {
 	})
 }
 
-func sqlDatabaseProvider(ctx context.Context) lazy.Loader[sql.Database] {
-	return lazy.New(func() (sql.Database, error) {
+func sqlConfigProvider() lazy.Loader[*sql.Config] {
+	return lazy.New(func() (*sql.Config, error) {
 		sqlConfig := &sql.Config{
 			DSN: sql.DSN{
 				User:     env.Must(env.Parse[string]("SQL_USER")),
@@ -165,7 +201,13 @@ func sqlDatabaseProvider(ctx context.Context) lazy.Loader[sql.Database] {
 			sqlConfig.ConnectionTimeout = *sqlConnTimeout
 		}
 
-		db, err := sql.NewDatabase(ctx, sqlConfig)
+		return sqlConfig, nil
+	})
+}
+
+func sqlDatabaseProvider(ctx context.Context, sqlConfig lazy.Loader[*sql.Config]) lazy.Loader[sql.Database] {
+	return lazy.New(func() (sql.Database, error) {
+		db, err := sql.NewDatabase(ctx, sqlConfig.MustLoad())
 		if err != nil {
 			panic(fmt.Errorf("open sql connection: %w", err))
 		}
@@ -204,6 +246,26 @@ func sqlIDKStorageProvider(
 	})
 }
 
+func sqlRecurringTaskStorageProvider(
+	db lazy.Loader[sql.Database],
+	dbMigrations lazy.Loader[SQLMigrations],
+) lazy.Loader[message.RecurringTaskStorage] {
+	return lazy.New(func() (message.RecurringTaskStorage, error) {
+		dbMigrations.MustLoad().MustRegister(sql.RecurringTaskMigrations)
+		return sql.NewRecurringTaskStorage(db.MustLoad()), nil
+	})
+}
+
+func sqlEventStoreStorageProvider(
+	db lazy.Loader[sql.Database],
+	dbMigrations lazy.Loader[SQLMigrations],
+) lazy.Loader[eventstore.Storage] {
+	return lazy.New(func() (eventstore.Storage, error) {
+		dbMigrations.MustLoad().MustRegister(sql.EventStoreMigrations)
+		return sql.NewEventStoreStorage(db.MustLoad()), nil
+	})
+}
+
 func httpServerProvider(
 	observer lazy.Loader[observability.Observer],
 	metrics lazy.Loader[metric.Metrics],
@@ -252,7 +314,8 @@ func httpClientFactoryProvider(
 }
 
 func messageBusListenerProvider(
-	msgConsumers lazy.Loader[message.ConsumerProvider[message.AckStrategy]],
+	msgConsumers lazy.Loader[message.ConsumerProvider[message.AckNackStrategy]],
+	msgSchemaRegistry lazy.Loader[*message.SchemaRegistry],
 	observer lazy.Loader[observability.Observer],
 	metrics lazy.Loader[metric.Metrics],
 	logger lazy.Loader[log.Logger],
@@ -260,8 +323,25 @@ func messageBusListenerProvider(
 	return lazy.New(func() (message.BusListener, error) {
 		return message.NewBusListener(
 			msgConsumers.MustLoad(),
-			message.NewAckQueue,
-			func() message.Deserializer { return message.NewJSONSerializer() },
+			message.NewAckNackQueue,
+			func() message.Deserializer {
+				return message.NewJSONSerializer(message.WithJSONSerializerSchemaRegistry(msgSchemaRegistry.MustLoad()))
+			},
+			message.WithHandlerRetry(func() backoff.BackOff {
+				return backoff.WithMaxRetries(backoff.NewExponentialBackOff(
+					backoff.WithInitialInterval(100*time.Millisecond),
+					backoff.WithMultiplier(2),
+					backoff.WithMaxElapsedTime(0),
+				), messageHandlerInProcessRetries)
+			}),
+			message.WithHandlerTimeout(messageHandlerTimeout),
+			message.WithHandlerDrainTimeout(messageHandlerDrainTimeout),
+			message.WithHandlerCircuitBreaker(message.CircuitBreakerConfig{
+				FailureRatio: 0.5,
+				MinAttempts:  20,
+				Window:       time.Minute,
+				OpenTimeout:  30 * time.Second,
+			}),
 			message.WithHandlerObservability(observer.MustLoad(), observability.FieldRequestID),
 			message.WithHandlerMetrics(metrics.MustLoad()),
 			message.WithHandlerLogging(logger.MustLoad(), log.LevelInfo, log.LevelError),
@@ -284,8 +364,86 @@ func messageStorageConsumerProvider(
 	})
 }
 
+func messageStorageListenerProvider(
+	sqlConfig lazy.Loader[*sql.Config],
+	msgStorageConsumers lazy.Loader[message.StorageConsumerProvider],
+	logger lazy.Loader[log.Logger],
+) lazy.Loader[worker.ContextJob] {
+	return lazy.New(func() (worker.ContextJob, error) {
+		return sql.NewMessageStorageListener(
+			&sqlConfig.MustLoad().DSN,
+			logger.MustLoad(),
+			msgStorageConsumers.MustLoad().ProcessTopic,
+		), nil
+	})
+}
+
+func messageOutboxRelayProvider(
+	sqlConfig lazy.Loader[*sql.Config],
+	msgStorage lazy.Loader[message.Storage],
+	metrics lazy.Loader[metric.Metrics],
+	logger lazy.Loader[log.Logger],
+) lazy.Loader[MessageOutboxRelay] {
+	return lazy.New(func() (MessageOutboxRelay, error) {
+		topicNames := env.Must(env.ParseList[string]("MESSAGE_OUTBOX_TOPICS", ","))
+		topics := make([]message.Topic, 0, len(topicNames))
+		for _, name := range topicNames {
+			topics = append(topics, message.Topic(name))
+		}
+
+		return NewMessageOutboxRelay(
+			msgStorage.MustLoad(),
+			&sqlConfig.MustLoad().DSN,
+			topics,
+			metrics.MustLoad(),
+			logger.MustLoad(),
+		), nil
+	})
+}
+
+func messageStorageAdminProvider(
+	msgStorage lazy.Loader[message.Storage],
+	msgSchemaRegistry lazy.Loader[*message.SchemaRegistry],
+	db lazy.Loader[sql.Database],
+) lazy.Loader[*message.StorageAdmin] {
+	return lazy.New(func() (*message.StorageAdmin, error) {
+		return message.NewStorageAdmin(
+			msgStorage.MustLoad(),
+			sql.NewTransaction(db.MustLoad(), "message_storage_admin", nil),
+			func() message.Deserializer {
+				return message.NewJSONSerializer(message.WithJSONSerializerSchemaRegistry(msgSchemaRegistry.MustLoad()))
+			},
+		), nil
+	})
+}
+
+func messageStorageMetricsProvider(
+	msgStorage lazy.Loader[message.Storage],
+	metrics lazy.Loader[metric.Metrics],
+) lazy.Loader[*message.StorageMetricsCollector] {
+	return lazy.New(func() (*message.StorageMetricsCollector, error) {
+		return message.NewStorageMetricsCollector(msgStorage.MustLoad(), metrics.MustLoad()), nil
+	})
+}
+
+func natsBrokerProvider(ctx context.Context, logger lazy.Loader[log.Logger]) lazy.Loader[*pkgnats.Broker] {
+	return lazy.New(func() (*pkgnats.Broker, error) {
+		broker, err := pkgnats.NewBroker(
+			ctx,
+			env.Must(env.Parse[string]("NATS_URL")),
+			pkgnats.WithLogging(logger.MustLoad(), log.LevelError),
+		)
+		if err != nil {
+			panic(fmt.Errorf("open nats connection: %w", err))
+		}
+
+		return broker, nil
+	})
+}
+
 func messageBusProducerProvider(
 	msgStorage lazy.Loader[message.Storage],
+	msgSchemaRegistry lazy.Loader[*message.SchemaRegistry],
 	observer lazy.Loader[observability.Observer],
 	metrics lazy.Loader[metric.Metrics],
 	logger lazy.Loader[log.Logger],
@@ -293,8 +451,9 @@ func messageBusProducerProvider(
 	return lazy.New(func() (message.BusScheduledProducer, error) {
 		return message.NewBusScheduledProducer(
 			msgStorage.MustLoad(),
-			message.NewJSONSerializer(),
+			message.NewJSONSerializer(message.WithJSONSerializerSchemaRegistry(msgSchemaRegistry.MustLoad())),
 			message.WithBusProducerObservability(observer.MustLoad(), observability.FieldRequestID),
+			message.WithBusProducerCorrelationField(observer.MustLoad(), observability.FieldRequestID),
 			message.WithBusProducerMetrics(metrics.MustLoad()),
 			message.WithBusProducerLogging(logger.MustLoad(), log.LevelDebug, log.LevelWarn),
 		), nil
@@ -313,8 +472,29 @@ func eventDispatcherProvider(busProducer lazy.Loader[message.BusScheduledProduce
 	})
 }
 
-func taskSchedulerProvider(busProducer lazy.Loader[message.BusScheduledProducer]) lazy.Loader[message.TaskScheduler] {
+func taskSchedulerProvider(
+	busProducer lazy.Loader[message.BusScheduledProducer],
+	msgStorage lazy.Loader[message.Storage],
+) lazy.Loader[message.TaskScheduler] {
 	return lazy.New(func() (message.TaskScheduler, error) {
-		return message.NewTaskScheduler(busProducer.MustLoad()), nil
+		return message.NewTaskScheduler(busProducer.MustLoad(), msgStorage.MustLoad()), nil
+	})
+}
+
+func recurringTaskSchedulerProvider(
+	taskScheduler lazy.Loader[message.TaskScheduler],
+	recurringTaskStorage lazy.Loader[message.RecurringTaskStorage],
+	db lazy.Loader[sql.Database],
+	metrics lazy.Loader[metric.Metrics],
+	logger lazy.Loader[log.Logger],
+) lazy.Loader[message.RecurringTaskScheduler] {
+	return lazy.New(func() (message.RecurringTaskScheduler, error) {
+		return message.NewRecurringTaskScheduler(
+			taskScheduler.MustLoad(),
+			recurringTaskStorage.MustLoad(),
+			sql.NewTransaction(db.MustLoad(), "recurring_task", nil),
+			message.WithRecurringTaskMetrics(metrics.MustLoad()),
+			message.WithRecurringTaskLogging(logger.MustLoad(), log.LevelInfo, log.LevelError),
+		), nil
 	})
 }
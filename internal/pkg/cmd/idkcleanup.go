@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/klwxsrx/go-service-template/pkg/idk"
+	"github.com/klwxsrx/go-service-template/pkg/message"
+)
+
+const (
+	idkCleanupDomainName = "idk"
+	idkCleanupSchedule   = "0 3 * * *"
+)
+
+var (
+	taskQueueIDKCleanup       = message.NewTopicTaskQueue(idkCleanupDomainName, TaskIDKCleanup{}.Type())
+	idkCleanupSubscriberName  = message.NewSubscriberService(idkCleanupDomainName)
+	idkCleanupTaskNamespaceID = uuid.MustParse("4f9c6a0e-5f8e-4d0b-9a3c-0c8e2f9a7b11")
+)
+
+// TaskIDKCleanup deletes the outdated idempotency keys, the task ID is derived from the occurrence,
+// so the same occurrence is never handled twice
+type TaskIDKCleanup struct {
+	Occurrence time.Time `json:"occurrence"`
+}
+
+func (t TaskIDKCleanup) ID() uuid.UUID {
+	return uuid.NewSHA1(idkCleanupTaskNamespaceID, []byte(t.Occurrence.UTC().Format(time.RFC3339)))
+}
+
+func (t TaskIDKCleanup) Type() string {
+	return "idk-cleanup"
+}
+
+// MustRegisterIDKCleanup schedules the idempotency keys cleanup daily
+func MustRegisterIDKCleanup(
+	taskScheduler message.TaskScheduler,
+	recurringTasks message.RecurringTaskRegistry,
+	handlers message.HandlerRegistry,
+	cleaner idk.Cleaner,
+) {
+	err := taskScheduler.Register(message.TopicMessages{
+		taskQueueIDKCleanup: {
+			message.RegisterTask[TaskIDKCleanup](),
+		},
+	})
+	if err != nil {
+		panic(fmt.Errorf("register idk cleanup task: %w", err))
+	}
+
+	schedule, err := message.NewCronSchedule(idkCleanupSchedule)
+	if err != nil {
+		panic(fmt.Errorf("create idk cleanup schedule: %w", err))
+	}
+
+	err = recurringTasks.RegisterRecurring(message.NewRecurringTask(
+		TaskIDKCleanup{}.Type(),
+		schedule,
+		message.CatchUpRunOnce,
+		func(occurrence time.Time) TaskIDKCleanup {
+			return TaskIDKCleanup{Occurrence: occurrence}
+		},
+	))
+	if err != nil {
+		panic(fmt.Errorf("register idk cleanup recurring task: %w", err))
+	}
+
+	err = handlers.RegisterHandlers(idkCleanupSubscriberName, message.TopicHandlers{
+		taskQueueIDKCleanup: {
+			message.RegisterTaskHandlers[TaskIDKCleanup](func(ctx context.Context, _ TaskIDKCleanup) error {
+				return cleaner.DeleteOutdated(ctx)
+			}),
+		},
+	})
+	if err != nil {
+		panic(fmt.Errorf("register idk cleanup task handlers: %w", err))
+	}
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/klwxsrx/go-service-template/pkg/log"
+	"github.com/klwxsrx/go-service-template/pkg/message"
+	"github.com/klwxsrx/go-service-template/pkg/metric"
+	"github.com/klwxsrx/go-service-template/pkg/sql"
+	"github.com/klwxsrx/go-service-template/pkg/worker"
+)
+
+// messageOutboxPollingInterval is the fallback for lost notifications and messages scheduled in the future
+const messageOutboxPollingInterval = 5 * time.Second
+
+// MessageOutboxRelay relays the stored messages of the allowed topics to the external broker,
+// other topics stay internal and are consumed by MessageStorageConsumers only
+type MessageOutboxRelay struct {
+	storage message.Storage
+	dsn     *sql.DSN
+	topics  []message.Topic
+	metrics metric.Metrics
+	logger  log.Logger
+}
+
+func NewMessageOutboxRelay(
+	storage message.Storage,
+	dsn *sql.DSN,
+	topics []message.Topic,
+	metrics metric.Metrics,
+	logger log.Logger,
+) MessageOutboxRelay {
+	return MessageOutboxRelay{
+		storage: storage,
+		dsn:     dsn,
+		topics:  topics,
+		metrics: metrics,
+		logger:  logger,
+	}
+}
+
+func (r MessageOutboxRelay) Workers(producer message.Producer) []worker.ContextJob {
+	outbox := message.NewOutbox(
+		r.storage,
+		producer,
+		message.WithOutboxTopics(r.topics...),
+		message.WithOutboxKeyOrdering(),
+		message.WithOutboxMetrics(r.metrics),
+		message.WithOutboxLogging(r.logger, log.LevelDebug, log.LevelWarn),
+	)
+
+	return []worker.ContextJob{
+		outbox.Worker,
+		sql.NewMessageStorageListener(r.dsn, r.logger, outbox.ProcessTopic),
+		worker.PeriodicalJob(outbox.Process, messageOutboxPollingInterval),
+	}
+}
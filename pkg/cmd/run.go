@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/klwxsrx/go-service-template/pkg/log"
 	"github.com/klwxsrx/go-service-template/pkg/worker"
@@ -15,7 +16,21 @@ func MustRun(ctx context.Context, logger log.Logger, job ...worker.ContextJob) {
 	}
 }
 
+// DefaultShutdownTimeout is the time the jobs have to finish their work after the stop signal
+const DefaultShutdownTimeout = 30 * time.Second
+
 func Run(ctx context.Context, logger log.Logger, job ...worker.ContextJob) error {
+	return RunWithShutdownTimeout(ctx, logger, DefaultShutdownTimeout, job...)
+}
+
+// RunWithShutdownTimeout runs the jobs until one of them completes, then the context of the rest jobs is canceled.
+// The jobs could finish their in-flight work within the worker.ShutdownContext, which is canceled after the shutdownTimeout
+func RunWithShutdownTimeout(
+	ctx context.Context,
+	logger log.Logger,
+	shutdownTimeout time.Duration,
+	job ...worker.ContextJob,
+) error {
 	errCompleted := errors.New("job completed")
 	loggingAdapter := func(ctx context.Context, job worker.ContextJob, logger log.Logger) worker.ErrorJob {
 		return func() error {
@@ -29,9 +44,30 @@ func Run(ctx context.Context, logger log.Logger, job ...worker.ContextJob) error
 		}
 	}
 
+	shutdownCtx, cancelShutdown := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelShutdown()
+
 	groupCtx, group := worker.NewGroup(ctx)
+	go func() {
+		select {
+		case <-groupCtx.Done():
+		case <-shutdownCtx.Done():
+			return
+		}
+
+		timer := time.NewTimer(shutdownTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			logger.Warn(shutdownCtx, "shutdown timeout exceeded, canceling the running jobs")
+			cancelShutdown()
+		case <-shutdownCtx.Done():
+		}
+	}()
+
+	jobCtx := worker.WithShutdownContext(groupCtx, shutdownCtx)
 	for _, j := range job {
-		group.Do(loggingAdapter(groupCtx, j, logger))
+		group.Do(loggingAdapter(jobCtx, j, logger))
 	}
 
 	err := group.Wait()
@@ -18,8 +18,9 @@ type (
 		Dispatch(ctx context.Context, events ...Event) error
 	}
 
-	TypedHandler[T Event] func(ctx context.Context, event T) error
-	Handler               TypedHandler[Event]
+	TypedHandler[T Event]      func(ctx context.Context, event T) error
+	TypedBatchHandler[T Event] func(ctx context.Context, events []T) error
+	Handler                    TypedHandler[Event]
 
 	RegisterHandlerFunc func() (eventType string, handler Handler, err error)
 
@@ -0,0 +1,45 @@
+package eventstore
+
+import (
+	"github.com/google/uuid"
+
+	"github.com/klwxsrx/go-service-template/pkg/event"
+)
+
+type (
+	// Aggregate is rehydrated from its event stream, the aggregate methods apply the events to the state
+	// and record them as changes, see Root
+	Aggregate interface {
+		AggregateID() uuid.UUID
+		// Version is the number of the stored events applied to the aggregate
+		Version() int
+		// Changes are the recorded events not stored yet
+		Changes() []event.Event
+		// MarkCommitted sets the stream version and clears the changes
+		MarkCommitted(version int)
+	}
+
+	// Root implements the Aggregate except AggregateID, embed it into the aggregate struct
+	Root struct {
+		version int
+		changes []event.Event
+	}
+)
+
+func (r *Root) Version() int {
+	return r.version
+}
+
+func (r *Root) Changes() []event.Event {
+	return r.changes
+}
+
+func (r *Root) MarkCommitted(version int) {
+	r.version = version
+	r.changes = nil
+}
+
+// Record adds the event already applied to the aggregate state to the changes
+func (r *Root) Record(evt event.Event) {
+	r.changes = append(r.changes, evt)
+}
@@ -0,0 +1,227 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/klwxsrx/go-service-template/pkg/event"
+	"github.com/klwxsrx/go-service-template/pkg/persistence"
+)
+
+var (
+	ErrAggregateNotFound      = errors.New("aggregate not found")
+	ErrConcurrentModification = errors.New("aggregate stream modified concurrently")
+	ErrSnapshotNotFound       = errors.New("aggregate snapshot not found")
+)
+
+type (
+	Storage interface {
+		// Append adds the events to the stream if it has the expectedVersion, returns ErrConcurrentModification otherwise
+		Append(ctx context.Context, aggregateName string, aggregateID uuid.UUID, expectedVersion int, events []StoredEvent) error
+		// Load returns the stream events after the version ordered by version
+		Load(ctx context.Context, aggregateID uuid.UUID, afterVersion int) ([]StoredEvent, error)
+		// LoadSnapshot returns ErrSnapshotNotFound if the aggregate has no snapshot
+		LoadSnapshot(ctx context.Context, aggregateID uuid.UUID) (Snapshot, error)
+		SaveSnapshot(ctx context.Context, snapshot Snapshot) error
+	}
+
+	StoredEvent struct {
+		ID         uuid.UUID
+		Type       string
+		Version    int
+		Payload    []byte
+		RecordedAt time.Time
+	}
+
+	Snapshot struct {
+		AggregateID uuid.UUID
+		Version     int
+		Payload     []byte
+	}
+
+	StoreOption[T Aggregate] func(*Store[T])
+
+	// Store keeps the aggregates as the append-only event streams, the appended events are dispatched
+	// within the same transaction, so use the message.EventDispatcher backed by the same database
+	Store[T Aggregate] struct {
+		// SnapshotEvery saves the aggregate snapshot every specified number of events, zero value disables snapshots
+		SnapshotEvery int
+
+		aggregateName string
+		newAggregate  func(uuid.UUID) T
+		storage       Storage
+		transaction   persistence.Transaction
+		dispatcher    event.Dispatcher
+		appliers      map[string]func(T, []byte) error
+	}
+)
+
+func NewStore[T Aggregate](
+	aggregateName string,
+	newAggregate func(uuid.UUID) T,
+	storage Storage,
+	transaction persistence.Transaction,
+	dispatcher event.Dispatcher,
+	opts ...StoreOption[T],
+) *Store[T] {
+	s := &Store[T]{
+		SnapshotEvery: 0,
+
+		aggregateName: aggregateName,
+		newAggregate:  newAggregate,
+		storage:       storage,
+		transaction:   transaction,
+		dispatcher:    dispatcher,
+		appliers:      make(map[string]func(T, []byte) error),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Load rehydrates the aggregate from the latest snapshot and the events stored after it
+func (s *Store[T]) Load(ctx context.Context, id uuid.UUID) (T, error) {
+	aggregate := s.newAggregate(id)
+
+	var version int
+	if s.SnapshotEvery > 0 {
+		snapshot, err := s.storage.LoadSnapshot(ctx, id)
+		if err != nil && !errors.Is(err, ErrSnapshotNotFound) {
+			return aggregate, fmt.Errorf("load %s %v snapshot: %w", s.aggregateName, id, err)
+		}
+		if err == nil {
+			err = json.Unmarshal(snapshot.Payload, aggregate)
+			if err != nil {
+				return aggregate, fmt.Errorf("decode %s %v snapshot: %w", s.aggregateName, id, err)
+			}
+			version = snapshot.Version
+		}
+	}
+
+	events, err := s.storage.Load(ctx, id, version)
+	if err != nil {
+		return aggregate, fmt.Errorf("load %s %v events: %w", s.aggregateName, id, err)
+	}
+	if version == 0 && len(events) == 0 {
+		return aggregate, fmt.Errorf("%w: %s %v", ErrAggregateNotFound, s.aggregateName, id)
+	}
+
+	for _, evt := range events {
+		apply, ok := s.appliers[evt.Type]
+		if !ok {
+			return aggregate, fmt.Errorf("applier not registered for %s event %s", s.aggregateName, evt.Type)
+		}
+
+		err = apply(aggregate, evt.Payload)
+		if err != nil {
+			return aggregate, fmt.Errorf("apply %s event %v: %w", evt.Type, evt.ID, err)
+		}
+		version = evt.Version
+	}
+
+	aggregate.MarkCommitted(version)
+	return aggregate, nil
+}
+
+// Save appends the aggregate changes to its stream, returns ErrConcurrentModification
+// if the stream was modified after the aggregate was loaded
+func (s *Store[T]) Save(ctx context.Context, aggregate T) error {
+	changes := aggregate.Changes()
+	if len(changes) == 0 {
+		return nil
+	}
+
+	id, version := aggregate.AggregateID(), aggregate.Version()
+	recordedAt := time.Now()
+	events := make([]StoredEvent, 0, len(changes))
+	for i, evt := range changes {
+		if evt.AggregateID() != id {
+			return fmt.Errorf("event %s %v belongs to another aggregate %v", evt.Type(), evt.ID(), evt.AggregateID())
+		}
+
+		payload, err := json.Marshal(evt)
+		if err != nil {
+			return fmt.Errorf("encode event %s %v: %w", evt.Type(), evt.ID(), err)
+		}
+
+		events = append(events, StoredEvent{
+			ID:         evt.ID(),
+			Type:       evt.Type(),
+			Version:    version + i + 1,
+			Payload:    payload,
+			RecordedAt: recordedAt,
+		})
+	}
+
+	newVersion := version + len(changes)
+	err := s.transaction.WithinContext(ctx, func(ctx context.Context) error {
+		err := s.storage.Append(ctx, s.aggregateName, id, version, events)
+		if err != nil {
+			return fmt.Errorf("append %s %v events: %w", s.aggregateName, id, err)
+		}
+
+		err = s.dispatcher.Dispatch(ctx, changes...)
+		if err != nil {
+			return fmt.Errorf("dispatch %s %v events: %w", s.aggregateName, id, err)
+		}
+
+		if s.SnapshotEvery <= 0 || newVersion/s.SnapshotEvery == version/s.SnapshotEvery {
+			return nil
+		}
+
+		payload, err := json.Marshal(aggregate)
+		if err != nil {
+			return fmt.Errorf("encode %s %v snapshot: %w", s.aggregateName, id, err)
+		}
+
+		err = s.storage.SaveSnapshot(ctx, Snapshot{
+			AggregateID: id,
+			Version:     newVersion,
+			Payload:     payload,
+		})
+		if err != nil {
+			return fmt.Errorf("save %s %v snapshot: %w", s.aggregateName, id, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	aggregate.MarkCommitted(newVersion)
+	return nil
+}
+
+// WithEventApplier registers the function applying the stored event of type E to the aggregate on rehydration
+func WithEventApplier[T Aggregate, E event.Event](apply func(T, E) error) StoreOption[T] {
+	var blank E
+	eventType := blank.Type()
+
+	return func(s *Store[T]) {
+		s.appliers[eventType] = func(aggregate T, payload []byte) error {
+			var evt E
+			err := json.Unmarshal(payload, &evt)
+			if err != nil {
+				return fmt.Errorf("json decode %T: %w", evt, err)
+			}
+
+			return apply(aggregate, evt)
+		}
+	}
+}
+
+// WithSnapshots saves the aggregate snapshot every specified number of events,
+// the aggregate state must be json serializable
+func WithSnapshots[T Aggregate](every int) StoreOption[T] {
+	return func(s *Store[T]) {
+		s.SnapshotEvery = every
+	}
+}
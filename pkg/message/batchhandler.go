@@ -0,0 +1,204 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultBatchMaxWait = 100 * time.Millisecond
+
+type (
+	// BatchItemErrors is returned by the batch handler to fail only the items with the specified indexes,
+	// other items of the batch are acknowledged
+	BatchItemErrors map[int]error
+
+	// BatchConfig flushes the batch when it reaches Size messages or MaxWait passed since the first message was added,
+	// zero MaxWait is defaultBatchMaxWait. The listener processes at least Size messages simultaneously to fill the batch,
+	// see MessageHandlers.BatchSize. The messages of the batch are still acknowledged one by one
+	BatchConfig struct {
+		Size    int
+		MaxWait time.Duration
+	}
+
+	batchHandler[T StructuredMessage] struct {
+		config  BatchConfig
+		handler func(context.Context, []T) error
+		mutex   *sync.Mutex
+		pending []batchItem[T]
+		timer   *time.Timer
+	}
+
+	batchItem[T StructuredMessage] struct {
+		Context context.Context
+		Message T
+		Result  chan error
+	}
+)
+
+func (e BatchItemErrors) Error() string {
+	indexes := make([]int, 0, len(e))
+	for i := range e {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	errs := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		errs = append(errs, fmt.Sprintf("item %d: %s", i, e[i]))
+	}
+
+	return fmt.Sprintf("batch items failed: %s", strings.Join(errs, "; "))
+}
+
+// newBatchHandler adapts the batch handler to the single message handler, every call waits until its batch is handled
+func newBatchHandler[T StructuredMessage](config BatchConfig, handler func(context.Context, []T) error) TypedHandler[T] {
+	if config.Size < 1 {
+		config.Size = 1
+	}
+	// the timer with zero duration flushes the batch right after the first message
+	if config.MaxWait <= 0 {
+		config.MaxWait = defaultBatchMaxWait
+	}
+
+	b := &batchHandler[T]{
+		config:  config,
+		handler: handler,
+		mutex:   &sync.Mutex{},
+		pending: nil,
+		timer:   nil,
+	}
+
+	return b.Handle
+}
+
+func (b *batchHandler[T]) Handle(ctx context.Context, msg T) error {
+	item := batchItem[T]{
+		Context: ctx,
+		Message: msg,
+		Result:  make(chan error, 1),
+	}
+
+	b.mutex.Lock()
+	b.pending = append(b.pending, item)
+	switch {
+	case len(b.pending) >= b.config.Size:
+		items := b.takePending()
+		b.mutex.Unlock()
+		b.flush(items)
+	case len(b.pending) == 1:
+		b.timer = time.AfterFunc(b.config.MaxWait, b.flushPending)
+		b.mutex.Unlock()
+	default:
+		b.mutex.Unlock()
+	}
+
+	select {
+	case err := <-item.Result:
+		return err
+	case <-ctx.Done():
+		b.removePending(item)
+		return ctx.Err()
+	}
+}
+
+// removePending drops the canceled item not flushed yet, so it isn't handled after the message was nacked
+func (b *batchHandler[T]) removePending(item batchItem[T]) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for i, pendingItem := range b.pending {
+		if pendingItem.Result != item.Result {
+			continue
+		}
+
+		b.pending = append(b.pending[:i], b.pending[i+1:]...)
+		if len(b.pending) == 0 && b.timer != nil {
+			b.timer.Stop()
+			b.timer = nil
+		}
+		return
+	}
+}
+
+func (b *batchHandler[T]) flushPending() {
+	b.mutex.Lock()
+	items := b.takePending()
+	b.mutex.Unlock()
+
+	if len(items) > 0 {
+		b.flush(items)
+	}
+}
+
+func (b *batchHandler[T]) takePending() []batchItem[T] {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	items := b.pending
+	b.pending = nil
+	return items
+}
+
+func (b *batchHandler[T]) flush(items []batchItem[T]) {
+	msgs := make([]T, 0, len(items))
+	for _, item := range items {
+		msgs = append(msgs, item.Message)
+	}
+
+	ctx, cancel := b.flushContext(items)
+	defer cancel()
+
+	err := b.handle(ctx, msgs)
+
+	var itemErrs BatchItemErrors
+	if !errors.As(err, &itemErrs) {
+		for _, item := range items {
+			item.Result <- err
+		}
+		return
+	}
+
+	for i, item := range items {
+		item.Result <- itemErrs[i]
+	}
+}
+
+// flushContext has the values of the first message context, it isn't canceled by the single message cancellation,
+// but it's limited by the earliest deadline of the batch messages
+func (b *batchHandler[T]) flushContext(items []batchItem[T]) (context.Context, context.CancelFunc) {
+	var (
+		deadline    time.Time
+		hasDeadline bool
+	)
+	for _, item := range items {
+		itemDeadline, ok := item.Context.Deadline()
+		if ok && (!hasDeadline || itemDeadline.Before(deadline)) {
+			deadline = itemDeadline
+			hasDeadline = true
+		}
+	}
+
+	ctx := context.WithoutCancel(items[0].Context)
+	if !hasDeadline {
+		return ctx, func() {}
+	}
+
+	return context.WithDeadline(ctx, deadline)
+}
+
+func (b *batchHandler[T]) handle(ctx context.Context, msgs []T) (err error) {
+	defer func() {
+		if panicMsg := recover(); panicMsg != nil {
+			err = fmt.Errorf("batch handler panic: %v", panicMsg)
+		}
+	}()
+
+	return b.handler(ctx, msgs)
+}
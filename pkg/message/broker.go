@@ -1,11 +1,20 @@
 package message
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type (
 	ConsumerMessage struct {
 		Context context.Context
 		Message Message
+		// Attempts is the number of the failed handler attempts of the previous deliveries and FirstFailedAt is
+		// the time of the first one, they are zero values if the broker doesn't track them. The listener updates
+		// them before the negative acknowledgement, the brokers unable to persist them count every failed delivery
+		// as a single attempt
+		Attempts      int
+		FirstFailedAt *time.Time
 	}
 
 	Consumer[S AcknowledgeStrategy] interface {
@@ -20,10 +29,26 @@ type (
 		Consumer(Topic, Subscriber) (Consumer[S], error)
 	}
 
+	// ConsumerWorker is implemented by the consumers pulling the messages within their own worker
+	ConsumerWorker interface {
+		Worker(context.Context) error
+	}
+
+	// TopicEnumerator is implemented by the consumer providers able to resolve the topic patterns, see Topic.Match
+	TopicEnumerator interface {
+		Topics(context.Context) ([]Topic, error)
+	}
+
 	Producer interface {
 		Produce(context.Context, *Message) error
 	}
 
+	// BatchProducer is implemented by producers able to send several messages at once, messages are sent in order
+	BatchProducer interface {
+		Producer
+		ProduceBatch(context.Context, []*Message) error
+	}
+
 	Broker[S AcknowledgeStrategy] interface {
 		ConsumerProvider[S]
 		Producer
@@ -40,7 +65,7 @@ type (
 
 	AckNackStrategy interface {
 		AckStrategy
-		Nack(context.Context, *ConsumerMessage) error
+		Nack(_ context.Context, _ *ConsumerMessage, reason error) error
 	}
 
 	AcknowledgeStrategy any
@@ -1,12 +1,20 @@
 package message
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/klwxsrx/go-service-template/pkg/worker"
 )
 
+const topicPatternResolveInterval = time.Minute
+
+// ErrTopicPatternNotMatched is returned on registration when the topic pattern matches none of the existing topics
+var ErrTopicPatternNotMatched = errors.New("topic pattern matches no topics")
+
 type (
 	HandlerRegistry interface {
 		RegisterHandlers(Subscriber, TopicHandlers, ...ListenerOption) error
@@ -17,16 +25,29 @@ type (
 		Workers() []worker.ContextJob
 	}
 
+	// TopicHandlers could contain topic patterns, they are resolved to the existing topics on registration
+	// by the consumer provider implementing TopicEnumerator, the pattern must match at least one topic.
+	// The patterns are resolved again periodically while the listener workers run, so the new topics are picked up.
+	// Dead-letter topics are matched by the dead-letter patterns only
 	TopicHandlers map[Topic][]RegisterHandlersFunc
 
 	busListener[S AcknowledgeStrategy] struct {
 		consumers    ConsumerProvider[S]
 		queue        ListenerQueueBuilder[S]
 		deserializer func() Deserializer
+		mutex        *sync.Mutex
 		listeners    map[subscriberKey]listenerData[S]
+		patterns     []topicPatternData
 		opts         []ListenerOption
 	}
 
+	topicPatternData struct {
+		Subscriber Subscriber
+		Pattern    Topic
+		Funcs      []RegisterHandlersFunc
+		Opts       []ListenerOption
+	}
+
 	listenerData[S AcknowledgeStrategy] struct {
 		Consumer     Consumer[S]
 		Deserializer Deserializer
@@ -50,15 +71,39 @@ func NewBusListener[S AcknowledgeStrategy](
 		consumers:    consumers,
 		queue:        processingQueue,
 		deserializer: deserializer,
+		mutex:        &sync.Mutex{},
 		listeners:    make(map[subscriberKey]listenerData[S]),
+		patterns:     nil,
 		opts:         opts,
 	}
 }
 
 func (b *busListener[S]) RegisterHandlers(subscriber Subscriber, handlers TopicHandlers, opts ...ListenerOption) error {
-	for topic, funcs := range handlers {
-		if err := b.registerTopicHandlers(subscriber, topic, funcs, opts...); err != nil {
-			return fmt.Errorf("register handler for topic %s by %s: %w", topic, subscriber, err)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for pattern, funcs := range handlers {
+		topics, err := b.resolveTopics(context.Background(), pattern)
+		if err != nil {
+			return fmt.Errorf("resolve topic %s for %s: %w", pattern, subscriber, err)
+		}
+		if len(topics) == 0 {
+			return fmt.Errorf("resolve topic %s for %s: %w", pattern, subscriber, ErrTopicPatternNotMatched)
+		}
+
+		for _, topic := range topics {
+			if err = b.registerTopicHandlers(subscriber, topic, funcs, opts...); err != nil {
+				return fmt.Errorf("register handler for topic %s by %s: %w", topic, subscriber, err)
+			}
+		}
+
+		if pattern.IsPattern() {
+			b.patterns = append(b.patterns, topicPatternData{
+				Subscriber: subscriber,
+				Pattern:    pattern,
+				Funcs:      funcs,
+				Opts:       opts,
+			})
 		}
 	}
 
@@ -66,20 +111,120 @@ func (b *busListener[S]) RegisterHandlers(subscriber Subscriber, handlers TopicH
 }
 
 func (b *busListener[S]) Workers() []worker.ContextJob {
-	listeners := make([]worker.ContextJob, 0, len(b.listeners))
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	listeners := make([]worker.ContextJob, 0, len(b.listeners)+1)
 	for _, data := range b.listeners {
-		listeners = append(listeners, NewListener[S](
-			data.Consumer,
-			data.Handlers,
-			b.queue,
-			data.Deserializer,
-			append(b.opts, data.ExtraOpts...)...,
-		))
+		listeners = append(listeners, b.listener(data))
+	}
+	if len(b.patterns) > 0 {
+		listeners = append(listeners, b.topicPatternWorker)
 	}
 
 	return listeners
 }
 
+func (b *busListener[S]) listener(data listenerData[S]) worker.ContextJob {
+	return NewListener[S](
+		data.Consumer,
+		data.Handlers,
+		b.queue,
+		data.Deserializer,
+		append(b.opts[:len(b.opts):len(b.opts)], data.ExtraOpts...)...,
+	)
+}
+
+// topicPatternWorker runs the listeners of the topics matched by the patterns after the registration
+func (b *busListener[S]) topicPatternWorker(ctx context.Context) error {
+	groupCtx, group := worker.NewGroup(ctx)
+	group.Do(func() error {
+		ticker := time.NewTicker(topicPatternResolveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-groupCtx.Done():
+				return nil
+			}
+
+			jobs, err := b.registerNewPatternTopics(groupCtx)
+			if err != nil {
+				return err
+			}
+			for _, job := range jobs {
+				group.Do(func() error {
+					return job(groupCtx)
+				})
+			}
+		}
+	})
+
+	return group.Wait()
+}
+
+func (b *busListener[S]) registerNewPatternTopics(ctx context.Context) ([]worker.ContextJob, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var jobs []worker.ContextJob
+	for _, pattern := range b.patterns {
+		topics, err := b.resolveTopics(ctx, pattern.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("resolve topic %s for %s: %w", pattern.Pattern, pattern.Subscriber, err)
+		}
+
+		for _, topic := range topics {
+			key := subscriberKey{pattern.Subscriber, topic}
+			if _, ok := b.listeners[key]; ok {
+				continue
+			}
+
+			err = b.registerTopicHandlers(pattern.Subscriber, topic, pattern.Funcs, pattern.Opts...)
+			if err != nil {
+				return nil, fmt.Errorf("register handler for topic %s by %s: %w", topic, pattern.Subscriber, err)
+			}
+
+			data := b.listeners[key]
+			if consumerWorker, ok := data.Consumer.(ConsumerWorker); ok {
+				jobs = append(jobs, consumerWorker.Worker)
+			}
+			jobs = append(jobs, b.listener(data))
+		}
+	}
+
+	return jobs, nil
+}
+
+func (b *busListener[S]) resolveTopics(ctx context.Context, pattern Topic) ([]Topic, error) {
+	if !pattern.IsPattern() {
+		return []Topic{pattern}, nil
+	}
+
+	enumerator, ok := b.consumers.(TopicEnumerator)
+	if !ok {
+		return nil, errors.New("consumer provider doesn't support topic patterns")
+	}
+
+	topics, err := enumerator.Topics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get topics: %w", err)
+	}
+
+	deadLetterPattern := isTopicDeadLetter(pattern)
+	result := make([]Topic, 0, len(topics))
+	for _, topic := range topics {
+		if topic.IsPattern() || !pattern.Match(topic) || isTopicDeadLetter(topic) != deadLetterPattern {
+			continue
+		}
+
+		result = append(result, topic)
+	}
+
+	return result, nil
+}
+
 func (b *busListener[S]) registerTopicHandlers(
 	subscriber Subscriber,
 	topic Topic,
@@ -95,10 +240,10 @@ func (b *busListener[S]) registerTopicHandlers(
 	topicMessageTypes := make(map[string]struct{}, len(funcs))
 	handlers := make(map[string][]TypedHandler[StructuredMessage], len(funcs))
 	for _, fn := range funcs {
-		msgSchema, msgDeserializer, msgHandlers := fn()
-		msgType := msgSchema.Type()
+		msgHandlers := fn()
+		msgType := msgHandlers.Schema.Type()
 		if msgType == "" {
-			return fmt.Errorf("blank message %T must return message type const value", msgSchema)
+			return fmt.Errorf("blank message %T must return message type const value", msgHandlers.Schema)
 		}
 
 		if _, ok := topicMessageTypes[msgType]; ok {
@@ -106,12 +251,18 @@ func (b *busListener[S]) registerTopicHandlers(
 		}
 		topicMessageTypes[msgType] = struct{}{}
 
-		err := deserializer.RegisterDeserializer(msgType, msgDeserializer)
+		err := deserializer.RegisterDeserializer(msgHandlers.Schema, msgHandlers.Deserializer, msgHandlers.Upcasters)
 		if err != nil {
-			return fmt.Errorf("register deserializer for %T: %w", msgSchema, err)
+			return fmt.Errorf("register deserializer for %T: %w", msgHandlers.Schema, err)
 		}
 
-		handlers[msgType] = msgHandlers
+		handlers[msgType] = msgHandlers.Handlers
+		if msgHandlers.Timeout > 0 {
+			opts = append(opts[:len(opts):len(opts)], withHandlerMessageTypeTimeout(msgType, msgHandlers.Timeout))
+		}
+		if msgHandlers.BatchSize > 1 {
+			opts = append(opts[:len(opts):len(opts)], withHandlerBatchSize(msgHandlers.BatchSize))
+		}
 	}
 
 	consumer, err := b.consumers.Consumer(topic, subscriber)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strconv"
 	"time"
 
 	"github.com/klwxsrx/go-service-template/pkg/log"
@@ -32,6 +33,11 @@ type (
 	BusProducerConfig struct {
 		Middlewares      []BusProducerMiddleware
 		MetadataBuilders []MetadataBuilder
+		PayloadEncoders  []PayloadEncoder
+		// CorrelationIDExtractors provide the correlation ID of the messages produced outside the handlers
+		CorrelationIDExtractors []func(context.Context) string
+		// Priority of the produced messages, override it for the call with WithProducePriority
+		Priority Priority
 	}
 	BusProducerMiddleware func(BusProduce) BusProduce
 	MetadataBuilder       func(context.Context) (Metadata, error)
@@ -51,7 +57,7 @@ type (
 		Message reflect.Type
 	}
 
-	producerImpl func(context.Context, *Message, *time.Time) error
+	producerImpl func(_ context.Context, _ *Message, _ *time.Time, deduplicationKey string) error
 )
 
 func NewBusProducer(
@@ -60,15 +66,18 @@ func NewBusProducer(
 	opts ...BusProducerOption,
 ) BusProducer {
 	config := BusProducerConfig{
-		Middlewares:      nil,
-		MetadataBuilders: nil,
+		Middlewares:             nil,
+		MetadataBuilders:        nil,
+		PayloadEncoders:         nil,
+		CorrelationIDExtractors: nil,
+		Priority:                PriorityNormal,
 	}
 	for _, opt := range opts {
 		opt(&config)
 	}
 
 	return &busProducerImpl{
-		producerImpl: func(ctx context.Context, message *Message, _ *time.Time) error {
+		producerImpl: func(ctx context.Context, message *Message, _ *time.Time, _ string) error {
 			err := producer.Produce(ctx, message)
 			if err != nil {
 				return fmt.Errorf("produce message: %w", err)
@@ -84,27 +93,37 @@ func NewBusProducer(
 	}
 }
 
+// NewBusScheduledProducer stores messages to be produced by the Outbox,
+// DeduplicatedMessage replaces the stored message with the same deduplication key
 func NewBusScheduledProducer(
 	storage Storage,
 	serializer Serializer,
 	opts ...BusProducerOption,
 ) BusScheduledProducer {
 	config := BusProducerConfig{
-		Middlewares:      nil,
-		MetadataBuilders: nil,
+		Middlewares:             nil,
+		MetadataBuilders:        nil,
+		PayloadEncoders:         nil,
+		CorrelationIDExtractors: nil,
+		Priority:                PriorityNormal,
 	}
 	for _, opt := range opts {
 		opt(&config)
 	}
 
 	return &busProducerImpl{
-		producerImpl: func(ctx context.Context, message *Message, at *time.Time) error {
+		producerImpl: func(ctx context.Context, message *Message, at *time.Time, deduplicationKey string) error {
 			scheduleAt := time.Now()
 			if at != nil {
 				scheduleAt = *at
 			}
 
-			err := storage.Store(ctx, scheduleAt, *message)
+			var err error
+			if deduplicationKey != "" {
+				err = storage.StoreDeduplicated(ctx, scheduleAt, deduplicationKey, *message)
+			} else {
+				err = storage.Store(ctx, scheduleAt, *message)
+			}
 			if err != nil {
 				return fmt.Errorf("store message: %w", err)
 			}
@@ -146,17 +165,23 @@ func (p *busProducerImpl) Schedule(ctx context.Context, at time.Time, msgs ...St
 
 func (p *busProducerImpl) createFromBaseConfig() BusProducerConfig {
 	config := BusProducerConfig{
-		Middlewares:      make([]BusProducerMiddleware, 0, len(p.baseConfig.Middlewares)),
-		MetadataBuilders: make([]MetadataBuilder, 0, len(p.baseConfig.MetadataBuilders)),
+		Middlewares:             make([]BusProducerMiddleware, 0, len(p.baseConfig.Middlewares)),
+		MetadataBuilders:        make([]MetadataBuilder, 0, len(p.baseConfig.MetadataBuilders)),
+		PayloadEncoders:         make([]PayloadEncoder, 0, len(p.baseConfig.PayloadEncoders)),
+		CorrelationIDExtractors: make([]func(context.Context) string, 0, len(p.baseConfig.CorrelationIDExtractors)),
+		Priority:                p.baseConfig.Priority,
 	}
 
 	config.Middlewares = append(config.Middlewares, p.baseConfig.Middlewares...)
 	config.MetadataBuilders = append(config.MetadataBuilders, p.baseConfig.MetadataBuilders...)
+	config.PayloadEncoders = append(config.PayloadEncoders, p.baseConfig.PayloadEncoders...)
+	config.CorrelationIDExtractors = append(config.CorrelationIDExtractors, p.baseConfig.CorrelationIDExtractors...)
 	return config
 }
 
 func (p *busProducerImpl) registerImpl(topic Topic, msg RegisterMessageFunc, config BusProducerConfig) error {
-	schema, keyBuilder := msg()
+	registered := msg()
+	schema := registered.Schema
 	msgType := schema.Type()
 	if msgType == "" {
 		return fmt.Errorf("blank message %T must return const value of type", schema)
@@ -170,18 +195,27 @@ func (p *busProducerImpl) registerImpl(topic Topic, msg RegisterMessageFunc, con
 		p.topicMessages[topic] = make(map[string]struct{})
 	}
 
+	err := p.serializer.RegisterSerializer(schema)
+	if err != nil {
+		return fmt.Errorf("register serializer for %s: %w", msgType, err)
+	}
+
 	msgReflectType := reflect.TypeOf(schema)
-	p.producers[producerKey{Topic: topic, Message: msgReflectType}] = p.buildProducer(config, keyBuilder)
+	p.producers[producerKey{Topic: topic, Message: msgReflectType}] = p.buildProducer(config, registered.KeyBuilder, registered.TTL)
 	p.messageTopics[msgReflectType] = append(p.messageTopics[msgReflectType], topic)
 	p.topicMessages[topic][msgType] = struct{}{}
 
 	return nil
 }
 
-func (p *busProducerImpl) buildProducer(config BusProducerConfig, keyBuilder KeyBuilder) BusProduce {
+func (p *busProducerImpl) buildProducer(config BusProducerConfig, keyBuilder KeyBuilder, ttl time.Duration) BusProduce {
 	metadataBuilders := config.MetadataBuilders
-	serializePayloadImpl := func(ctx context.Context, msg StructuredMessage) ([]byte, error) {
-		meta := make(Metadata, len(metadataBuilders))
+	payloadEncoders := config.PayloadEncoders
+	correlationIDExtractors := config.CorrelationIDExtractors
+	priority := config.Priority
+	serializePayloadImpl := func(ctx context.Context, msg StructuredMessage, at *time.Time) ([]byte, error) {
+		meta := correlationMetadata(ctx, msg, correlationIDExtractors)
+		meta[producedAtMetaKey] = producedAt(at).Format(time.RFC3339Nano)
 		for _, metaBuilder := range metadataBuilders {
 			tmpMeta, err := metaBuilder(ctx)
 			if err != nil {
@@ -197,6 +231,13 @@ func (p *busProducerImpl) buildProducer(config BusProducerConfig, keyBuilder Key
 			return nil, fmt.Errorf("serialize message %T: %w", msg, err)
 		}
 
+		for _, encode := range payloadEncoders {
+			payload, err = encode(payload)
+			if err != nil {
+				return nil, fmt.Errorf("encode payload of %T: %w", msg, err)
+			}
+		}
+
 		return payload, nil
 	}
 
@@ -205,19 +246,28 @@ func (p *busProducerImpl) buildProducer(config BusProducerConfig, keyBuilder Key
 	}
 
 	produce := func(ctx context.Context, topic Topic, msg StructuredMessage, at *time.Time) error {
-		payload, err := serializePayloadImpl(ctx, msg)
+		payload, err := serializePayloadImpl(ctx, msg, at)
 		if err != nil {
 			return fmt.Errorf("serialize message %T: %w", msg, err)
 		}
 
+		headers := getMessageHeaders(msg)
+		if msgPriority := getProducePriority(ctx, priority); msgPriority != PriorityNormal {
+			headers[PriorityHeader] = strconv.Itoa(int(msgPriority))
+		}
+		if msgTTL := getProduceTTL(ctx, ttl); msgTTL > 0 {
+			headers[ExpiresAtHeader] = producedAt(at).Add(msgTTL).Format(time.RFC3339Nano)
+		}
+
 		rawMsg := &Message{
 			ID:      msg.ID(),
 			Topic:   topic,
 			Key:     keyBuilder(msg),
 			Payload: payload,
+			Headers: headers,
 		}
 
-		err = p.producerImpl(ctx, rawMsg, at)
+		err = p.producerImpl(ctx, rawMsg, at, GetMessageDeduplicationKey(msg))
 		if err != nil {
 			return fmt.Errorf("produce message: %w", err)
 		}
@@ -305,6 +355,23 @@ func WithBusProducerObservability(observer observability.Observer, fields ...obs
 	}
 }
 
+// WithBusProducerCorrelationField uses the observability field as the correlation ID
+// of the messages produced outside the handlers, e.g. the HTTP request ID
+func WithBusProducerCorrelationField(observer observability.Observer, field observability.Field) BusProducerOption {
+	return func(config *BusProducerConfig) {
+		config.CorrelationIDExtractors = append(config.CorrelationIDExtractors, func(ctx context.Context) string {
+			return observer.Field(ctx, field)
+		})
+	}
+}
+
+// WithBusProducerPayloadCodec compresses and encrypts serialized messages, listeners must use WithHandlerPayloadCodec
+func WithBusProducerPayloadCodec(codec *PayloadCodec) BusProducerOption {
+	return func(config *BusProducerConfig) {
+		config.PayloadEncoders = append(config.PayloadEncoders, codec.Encode)
+	}
+}
+
 func WithBusProducerMetrics(metrics metric.Metrics) BusProducerOption {
 	mw := func(impl BusProduce) BusProduce {
 		return func(ctx context.Context, topic Topic, msg StructuredMessage, scheduleAt *time.Time) error {
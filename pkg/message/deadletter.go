@@ -0,0 +1,117 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const deadLetterTopicSuffix = "dead-letter"
+
+type (
+	// DeadLetterPolicy limits handler retries, zero values mean no limit. The attempts and the elapsed time
+	// include the previous failed deliveries tracked by the broker, see ConsumerMessage.Attempts
+	DeadLetterPolicy struct {
+		MaxAttempts    int
+		MaxElapsedTime time.Duration
+	}
+
+	// DeadLetter is the payload of the message produced to the dead-letter topic,
+	// it contains the original message with the failure details
+	DeadLetter struct {
+		MessageID  uuid.UUID         `json:"messageID"`
+		Topic      Topic             `json:"topic"`
+		Key        string            `json:"key"`
+		Payload    []byte            `json:"payload"`
+		Headers    map[string]string `json:"headers,omitempty"`
+		Subscriber Subscriber        `json:"subscriber"`
+		Error      string            `json:"error"`
+		Attempts   int               `json:"attempts"`
+		FailedAt   time.Time         `json:"failedAt"`
+		Metadata   Metadata          `json:"metadata,omitempty"`
+	}
+
+	handlerAttemptsExhaustedError struct {
+		Err      error
+		Attempts int
+	}
+)
+
+func (p DeadLetterPolicy) Exceeded(attempts int, elapsed time.Duration) bool {
+	if p.MaxAttempts > 0 && attempts >= p.MaxAttempts {
+		return true
+	}
+	if p.MaxElapsedTime > 0 && elapsed >= p.MaxElapsedTime {
+		return true
+	}
+
+	return false
+}
+
+func NewTopicDeadLetter(topic Topic) Topic {
+	return Topic(fmt.Sprintf("%s.%s", topic, deadLetterTopicSuffix))
+}
+
+func isTopicDeadLetter(topic Topic) bool {
+	return strings.HasSuffix(string(topic), topicTagSeparator+deadLetterTopicSuffix)
+}
+
+func DecodeDeadLetter(payload []byte) (*DeadLetter, error) {
+	var deadLetter DeadLetter
+	err := json.Unmarshal(payload, &deadLetter)
+	if err != nil {
+		return nil, fmt.Errorf("json decode dead letter: %w", err)
+	}
+
+	return &deadLetter, nil
+}
+
+func newDeadLetterMessage(
+	msg *Message,
+	subscriber Subscriber,
+	meta Metadata,
+	attempts int,
+	reason error,
+) (*Message, error) {
+	payload, err := json.Marshal(DeadLetter{
+		MessageID:  msg.ID,
+		Topic:      msg.Topic,
+		Key:        msg.Key,
+		Payload:    msg.Payload,
+		Headers:    msg.Headers,
+		Subscriber: subscriber,
+		Error:      reason.Error(),
+		Attempts:   attempts,
+		FailedAt:   time.Now(),
+		Metadata:   meta,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("json encode dead letter for %v: %w", msg.ID, err)
+	}
+
+	return &Message{
+		ID:      msg.ID,
+		Topic:   NewTopicDeadLetter(msg.Topic),
+		Key:     msg.Key,
+		Payload: payload,
+		Headers: nil,
+	}, nil
+}
+
+func (e *handlerAttemptsExhaustedError) Error() string {
+	return fmt.Sprintf("handler attempts exhausted after %d attempts: %s", e.Attempts, e.Err)
+}
+
+func (e *handlerAttemptsExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+func getHandlerAttemptsExhausted(err error) (*handlerAttemptsExhaustedError, bool) {
+	var exhaustedErr *handlerAttemptsExhaustedError
+	ok := errors.As(err, &exhaustedErr)
+	return exhaustedErr, ok
+}
@@ -47,7 +47,7 @@ func (d eventDispatcher) Register(messages TopicMessages, opts ...BusProducerOpt
 }
 
 func RegisterEvent[T event.Event]() RegisterMessageFunc {
-	return func() (StructuredMessage, KeyBuilder) {
+	return func() RegisteredMessage {
 		keyBuilder := func(msg StructuredMessage) string {
 			evt, ok := msg.(T)
 			if !ok {
@@ -58,12 +58,16 @@ func RegisterEvent[T event.Event]() RegisterMessageFunc {
 		}
 
 		var blank T
-		return blank, keyBuilder
+		return RegisteredMessage{
+			Schema:     blank,
+			KeyBuilder: keyBuilder,
+			TTL:        0,
+		}
 	}
 }
 
 func RegisterEventHandlers[T event.Event](handlers ...event.TypedHandler[T]) RegisterHandlersFunc {
-	return func() (StructuredMessage, PayloadDeserializer, []TypedHandler[StructuredMessage]) {
+	return func() MessageHandlers {
 		handlersImpl := make([]TypedHandler[StructuredMessage], 0, len(handlers))
 		for _, handler := range handlers {
 			handlersImpl = append(handlersImpl, func(ctx context.Context, msg StructuredMessage) error {
@@ -77,7 +81,28 @@ func RegisterEventHandlers[T event.Event](handlers ...event.TypedHandler[T]) Reg
 		}
 
 		var blank T
-		return blank, PayloadDeserializerImpl[T], handlersImpl
+		return MessageHandlers{
+			Schema:       blank,
+			Deserializer: PayloadDeserializerImpl[T],
+			Handlers:     handlersImpl,
+			Upcasters:    nil,
+			Timeout:      0,
+			BatchSize:    0,
+		}
+	}
+}
+
+// RegisterEventBatchHandlers registers handlers receiving the events in batches, see BatchConfig and BatchItemErrors
+func RegisterEventBatchHandlers[T event.Event](config BatchConfig, handlers ...event.TypedBatchHandler[T]) RegisterHandlersFunc {
+	return func() MessageHandlers {
+		typedHandlers := make([]event.TypedHandler[T], 0, len(handlers))
+		for _, handler := range handlers {
+			typedHandlers = append(typedHandlers, event.TypedHandler[T](newBatchHandler[T](config, handler)))
+		}
+
+		handlers := RegisterEventHandlers[T](typedHandlers...)()
+		handlers.BatchSize = config.Size
+		return handlers
 	}
 }
 
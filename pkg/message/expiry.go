@@ -0,0 +1,53 @@
+package message
+
+import (
+	"context"
+	"time"
+)
+
+// ExpiresAtHeader carries the time after which the stored message is dropped instead of delivering,
+// see WithMessageTTL and WithProduceTTL
+const ExpiresAtHeader = "message-expires-at"
+
+// WithMessageTTL drops the messages of the type not consumed within the ttl after they were scheduled
+func WithMessageTTL(register RegisterMessageFunc, ttl time.Duration) RegisterMessageFunc {
+	return func() RegisteredMessage {
+		registered := register()
+		registered.TTL = ttl
+		return registered
+	}
+}
+
+// WithProduceTTL overrides the ttl of the messages produced within the context, see WithMessageTTL
+func WithProduceTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, produceTTLContextKey, ttl)
+}
+
+// GetMessageExpiresAt returns false for the messages without expiry
+func GetMessageExpiresAt(msg *Message) (time.Time, bool) {
+	value, ok := msg.Headers[ExpiresAtHeader]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return expiresAt, true
+}
+
+func IsMessageExpired(msg *Message, now time.Time) bool {
+	expiresAt, ok := GetMessageExpiresAt(msg)
+	return ok && !now.Before(expiresAt)
+}
+
+func getProduceTTL(ctx context.Context, defaultTTL time.Duration) time.Duration {
+	ttl, ok := ctx.Value(produceTTLContextKey).(time.Duration)
+	if !ok {
+		return defaultTTL
+	}
+
+	return ttl
+}
@@ -0,0 +1,66 @@
+package message
+
+import "strconv"
+
+// MessageFilter decides by the Message.Headers whether the message is handled, see WithHandlerFilter
+type MessageFilter func(*Message) bool
+
+// FilterHeader matches the messages having the header with one of the values
+func FilterHeader(key string, values ...string) MessageFilter {
+	return func(msg *Message) bool {
+		value, ok := msg.Headers[key]
+		if !ok {
+			return false
+		}
+
+		for _, expected := range values {
+			if value == expected {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// FilterMinVersion matches the messages of the version not less than the specified one,
+// the messages without VersionHeader have version 1
+func FilterMinVersion(version int) MessageFilter {
+	return func(msg *Message) bool {
+		msgVersion := 1
+		if value, ok := msg.Headers[VersionHeader]; ok {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return false
+			}
+			msgVersion = parsed
+		}
+
+		return msgVersion >= version
+	}
+}
+
+// WithHandlerFilter acknowledges the messages not matching all the filters without the payload deserialization,
+// pass it to RegisterHandlers to filter the messages of the specific subscriber
+func WithHandlerFilter(filters ...MessageFilter) ListenerOption {
+	return func(l *ListenerImpl) {
+		l.Filters = append(l.Filters, filters...)
+	}
+}
+
+// matchFilters also filters out the message types without handlers if the message has TypeHeader
+func (l *ListenerImpl) matchFilters(msg *Message) bool {
+	if msgType, ok := msg.Headers[TypeHeader]; ok {
+		if _, ok = l.handlers[msgType]; !ok {
+			return false
+		}
+	}
+
+	for _, filter := range l.Filters {
+		if !filter(msg) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,494 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const defaultInMemoryBrokerPartitions = 1
+
+var ErrInMemoryBrokerClosed = errors.New("in-memory broker closed")
+
+type (
+	InMemoryBrokerOption func(*InMemoryBroker)
+
+	// InMemoryBroker keeps topics in process memory. Topics are split into partitions chosen by Message.Key,
+	// each Subscriber has its own independent offsets. Use CommitOffsetBroker or AckNackBroker
+	// to get the Broker with the required acknowledgement strategy.
+	InMemoryBroker struct {
+		Partitions          int
+		NackRedeliveryDelay time.Duration
+
+		mutex     *sync.Mutex
+		updated   chan struct{}
+		closed    bool
+		topics    map[Topic]*inMemoryTopic
+		groups    map[subscriberKey]*inMemoryGroup
+		consumers map[subscriberKey]*inMemoryConsumer
+	}
+
+	inMemoryBrokerView[S AcknowledgeStrategy] struct {
+		*InMemoryBroker
+		mode        inMemoryAckMode
+		acknowledge func(*inMemoryConsumer) S
+	}
+
+	inMemoryTypedConsumer[S AcknowledgeStrategy] struct {
+		*inMemoryConsumer
+		acknowledge S
+	}
+
+	inMemoryConsumer struct {
+		broker     *InMemoryBroker
+		key        subscriberKey
+		mode       inMemoryAckMode
+		group      *inMemoryGroup
+		messagesCh chan *ConsumerMessage
+		done       chan struct{}
+		stopped    chan struct{}
+		closeOnce  *sync.Once
+
+		// session state of the CommitOffsetStrategy mode
+		next     []int
+		inFlight []inMemoryPosition
+		sequence int
+
+		partitionCursor int
+	}
+
+	inMemoryTopic struct {
+		partitions [][]Message
+		roundRobin int
+	}
+
+	inMemoryGroup struct {
+		committed  []int
+		next       []int
+		pending    map[int]inMemoryRecord
+		redelivery []inMemoryRecord
+		sequence   int
+	}
+
+	inMemoryRecord struct {
+		Message  Message
+		Position inMemoryPosition
+		// Attempts and FirstFailedAt are tracked in AckNackStrategy mode only
+		Attempts      int
+		FirstFailedAt *time.Time
+	}
+
+	inMemoryPosition struct {
+		Partition int
+		Offset    int
+		Sequence  int
+	}
+
+	inMemoryAckMode int
+)
+
+const (
+	inMemoryCommitOffsetMode inMemoryAckMode = iota
+	inMemoryAckNackMode
+)
+
+func NewInMemoryBroker(opts ...InMemoryBrokerOption) *InMemoryBroker {
+	b := &InMemoryBroker{
+		Partitions:          defaultInMemoryBrokerPartitions,
+		NackRedeliveryDelay: 0,
+
+		mutex:     &sync.Mutex{},
+		updated:   make(chan struct{}),
+		closed:    false,
+		topics:    make(map[Topic]*inMemoryTopic),
+		groups:    make(map[subscriberKey]*inMemoryGroup),
+		consumers: make(map[subscriberKey]*inMemoryConsumer),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.Partitions < 1 {
+		b.Partitions = defaultInMemoryBrokerPartitions
+	}
+
+	return b
+}
+
+// CommitOffsetBroker returns the broker view whose consumers commit processed offsets per partition,
+// uncommitted messages are delivered again to the next consumer of the same Subscriber.
+func (b *InMemoryBroker) CommitOffsetBroker() Broker[CommitOffsetStrategy] {
+	return inMemoryBrokerView[CommitOffsetStrategy]{
+		InMemoryBroker: b,
+		mode:           inMemoryCommitOffsetMode,
+		acknowledge:    func(c *inMemoryConsumer) CommitOffsetStrategy { return c },
+	}
+}
+
+// AckNackBroker returns the broker view whose consumers acknowledge every message separately,
+// negatively acknowledged messages are redelivered after NackRedeliveryDelay.
+func (b *InMemoryBroker) AckNackBroker() Broker[AckNackStrategy] {
+	return inMemoryBrokerView[AckNackStrategy]{
+		InMemoryBroker: b,
+		mode:           inMemoryAckNackMode,
+		acknowledge:    func(c *inMemoryConsumer) AckNackStrategy { return c },
+	}
+}
+
+func (b *InMemoryBroker) Produce(_ context.Context, msg *Message) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return ErrInMemoryBrokerClosed
+	}
+
+	topic := b.getTopic(msg.Topic)
+	partition := b.partition(topic, msg.Key)
+	topic.partitions[partition] = append(topic.partitions[partition], *msg)
+
+	b.notifyUpdated()
+	return nil
+}
+
+func (b *InMemoryBroker) Close() error {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return nil
+	}
+	b.closed = true
+
+	consumers := make([]*inMemoryConsumer, 0, len(b.consumers))
+	for _, consumer := range b.consumers {
+		consumers = append(consumers, consumer)
+	}
+	b.mutex.Unlock()
+
+	for _, consumer := range consumers {
+		_ = consumer.Close()
+	}
+
+	return nil
+}
+
+func (b *InMemoryBroker) Topics(context.Context) ([]Topic, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	topics := make([]Topic, 0, len(b.topics))
+	for topic := range b.topics {
+		topics = append(topics, topic)
+	}
+
+	return topics, nil
+}
+
+func (v inMemoryBrokerView[S]) Consumer(topic Topic, subscriber Subscriber) (Consumer[S], error) {
+	consumer, err := v.openConsumer(topic, subscriber, v.mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return inMemoryTypedConsumer[S]{
+		inMemoryConsumer: consumer,
+		acknowledge:      v.acknowledge(consumer),
+	}, nil
+}
+
+func (c inMemoryTypedConsumer[S]) Acknowledge() S {
+	return c.acknowledge
+}
+
+func (b *InMemoryBroker) openConsumer(topic Topic, subscriber Subscriber, mode inMemoryAckMode) (*inMemoryConsumer, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil, ErrInMemoryBrokerClosed
+	}
+
+	key := subscriberKey{Subscriber: subscriber, Topic: topic}
+	if _, ok := b.consumers[key]; ok {
+		return nil, fmt.Errorf("consumer for topic %s by %s already exists, only one is supported at a time", topic, subscriber)
+	}
+
+	group, ok := b.groups[key]
+	if !ok {
+		group = &inMemoryGroup{
+			committed:  make([]int, b.Partitions),
+			next:       make([]int, b.Partitions),
+			pending:    make(map[int]inMemoryRecord),
+			redelivery: nil,
+			sequence:   0,
+		}
+		b.groups[key] = group
+	}
+
+	consumer := &inMemoryConsumer{
+		broker:     b,
+		key:        key,
+		mode:       mode,
+		group:      group,
+		messagesCh: make(chan *ConsumerMessage),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		closeOnce:  &sync.Once{},
+		next:       append(make([]int, 0, b.Partitions), group.committed...),
+		inFlight:   nil,
+		sequence:   0,
+
+		partitionCursor: 0,
+	}
+	b.consumers[key] = consumer
+	b.getTopic(topic)
+
+	go consumer.deliver()
+	return consumer, nil
+}
+
+func (b *InMemoryBroker) getTopic(name Topic) *inMemoryTopic {
+	topic, ok := b.topics[name]
+	if !ok {
+		topic = &inMemoryTopic{
+			partitions: make([][]Message, b.Partitions),
+			roundRobin: 0,
+		}
+		b.topics[name] = topic
+	}
+
+	return topic
+}
+
+func (b *InMemoryBroker) partition(topic *inMemoryTopic, key string) int {
+	if key == "" {
+		partition := topic.roundRobin % len(topic.partitions)
+		topic.roundRobin++
+		return partition
+	}
+
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(len(topic.partitions))) //nolint:gosec
+}
+
+func (b *InMemoryBroker) notifyUpdated() {
+	close(b.updated)
+	b.updated = make(chan struct{})
+}
+
+func (c *inMemoryConsumer) Topic() Topic {
+	return c.key.Topic
+}
+
+func (c *inMemoryConsumer) Subscriber() Subscriber {
+	return c.key.Subscriber
+}
+
+func (c *inMemoryConsumer) Messages() <-chan *ConsumerMessage {
+	return c.messagesCh
+}
+
+func (c *inMemoryConsumer) CommitOffset(_ context.Context, msg *ConsumerMessage) error {
+	position, ok := msg.Context.Value(inMemoryPositionContextKey).(inMemoryPosition)
+	if !ok {
+		return fmt.Errorf("message %v wasn't consumed from in-memory broker", msg.Message.ID)
+	}
+
+	c.broker.mutex.Lock()
+	defer c.broker.mutex.Unlock()
+
+	index := -1
+	for i := range c.inFlight {
+		if c.inFlight[i].Sequence == position.Sequence {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return fmt.Errorf("message %v is not in flight", msg.Message.ID)
+	}
+
+	for _, committed := range c.inFlight[:index+1] {
+		c.group.committed[committed.Partition] = max(c.group.committed[committed.Partition], committed.Offset+1)
+	}
+	c.inFlight = c.inFlight[index+1:]
+	return nil
+}
+
+func (c *inMemoryConsumer) Ack(_ context.Context, msg *ConsumerMessage) error {
+	position, ok := msg.Context.Value(inMemoryPositionContextKey).(inMemoryPosition)
+	if !ok {
+		return fmt.Errorf("message %v wasn't consumed from in-memory broker", msg.Message.ID)
+	}
+
+	c.broker.mutex.Lock()
+	defer c.broker.mutex.Unlock()
+
+	if _, ok = c.group.pending[position.Sequence]; !ok {
+		return fmt.Errorf("message %v is not in flight", msg.Message.ID)
+	}
+
+	delete(c.group.pending, position.Sequence)
+	return nil
+}
+
+func (c *inMemoryConsumer) Nack(_ context.Context, msg *ConsumerMessage, _ error) error {
+	position, ok := msg.Context.Value(inMemoryPositionContextKey).(inMemoryPosition)
+	if !ok {
+		return fmt.Errorf("message %v wasn't consumed from in-memory broker", msg.Message.ID)
+	}
+
+	c.broker.mutex.Lock()
+	defer c.broker.mutex.Unlock()
+
+	record, ok := c.group.pending[position.Sequence]
+	if !ok {
+		return fmt.Errorf("message %v is not in flight", msg.Message.ID)
+	}
+	delete(c.group.pending, position.Sequence)
+
+	record.Attempts = max(record.Attempts+1, msg.Attempts)
+	record.FirstFailedAt = msg.FirstFailedAt
+	if record.FirstFailedAt == nil {
+		now := time.Now()
+		record.FirstFailedAt = &now
+	}
+
+	if c.broker.NackRedeliveryDelay <= 0 {
+		c.group.redelivery = append(c.group.redelivery, record)
+		c.broker.notifyUpdated()
+		return nil
+	}
+
+	time.AfterFunc(c.broker.NackRedeliveryDelay, func() {
+		c.broker.mutex.Lock()
+		defer c.broker.mutex.Unlock()
+
+		c.group.redelivery = append(c.group.redelivery, record)
+		c.broker.notifyUpdated()
+	})
+	return nil
+}
+
+func (c *inMemoryConsumer) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		<-c.stopped
+
+		c.broker.mutex.Lock()
+		defer c.broker.mutex.Unlock()
+
+		for _, record := range c.group.pending {
+			c.group.redelivery = append(c.group.redelivery, record)
+		}
+		clear(c.group.pending)
+		delete(c.broker.consumers, c.key)
+
+		close(c.messagesCh)
+	})
+
+	return nil
+}
+
+func (c *inMemoryConsumer) deliver() {
+	defer close(c.stopped)
+
+	for {
+		record, updated, ok := c.fetchNext()
+		if !ok {
+			select {
+			case <-updated:
+				continue
+			case <-c.done:
+				return
+			}
+		}
+
+		msg := &ConsumerMessage{
+			Context:       context.WithValue(context.Background(), inMemoryPositionContextKey, record.Position),
+			Message:       record.Message,
+			Attempts:      record.Attempts,
+			FirstFailedAt: record.FirstFailedAt,
+		}
+
+		select {
+		case c.messagesCh <- msg:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *inMemoryConsumer) fetchNext() (_ inMemoryRecord, updated <-chan struct{}, ok bool) {
+	c.broker.mutex.Lock()
+	defer c.broker.mutex.Unlock()
+
+	if c.mode == inMemoryAckNackMode && len(c.group.redelivery) > 0 {
+		record := c.group.redelivery[0]
+		c.group.redelivery = c.group.redelivery[1:]
+		record.Position.Sequence = c.nextGroupSequence()
+		c.group.pending[record.Position.Sequence] = record
+		return record, nil, true
+	}
+
+	next := c.next
+	if c.mode == inMemoryAckNackMode {
+		next = c.group.next
+	}
+
+	topic := c.broker.topics[c.key.Topic]
+	for range len(topic.partitions) {
+		partition := c.partitionCursor % len(topic.partitions)
+		c.partitionCursor++
+
+		offset := next[partition]
+		if offset >= len(topic.partitions[partition]) {
+			continue
+		}
+		next[partition]++
+
+		record := inMemoryRecord{
+			Message: topic.partitions[partition][offset],
+			Position: inMemoryPosition{
+				Partition: partition,
+				Offset:    offset,
+				Sequence:  0,
+			},
+			Attempts:      0,
+			FirstFailedAt: nil,
+		}
+
+		if c.mode == inMemoryAckNackMode {
+			record.Position.Sequence = c.nextGroupSequence()
+			c.group.pending[record.Position.Sequence] = record
+		} else {
+			c.sequence++
+			record.Position.Sequence = c.sequence
+			c.inFlight = append(c.inFlight, record.Position)
+		}
+
+		return record, nil, true
+	}
+
+	return inMemoryRecord{}, c.broker.updated, false
+}
+
+func (c *inMemoryConsumer) nextGroupSequence() int {
+	c.group.sequence++
+	return c.group.sequence
+}
+
+func WithInMemoryBrokerPartitions(partitions int) InMemoryBrokerOption {
+	return func(b *InMemoryBroker) {
+		b.Partitions = partitions
+	}
+}
+
+func WithInMemoryBrokerNackRedeliveryDelay(delay time.Duration) InMemoryBrokerOption {
+	return func(b *InMemoryBroker) {
+		b.NackRedeliveryDelay = delay
+	}
+}
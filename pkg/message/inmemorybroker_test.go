@@ -0,0 +1,219 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	testBrokerTopic   Topic = "test.broker"
+	testBrokerTimeout       = 5 * time.Second
+)
+
+func TestInMemoryBrokerPartitionsByKey(t *testing.T) {
+	const partitions = 4
+	broker := NewInMemoryBroker(WithInMemoryBrokerPartitions(partitions))
+	consumer := openTestConsumer(t, broker.CommitOffsetBroker(), "subscriber")
+
+	keys := []string{"a", "b", "c", "a", "b", "c"}
+	for _, key := range keys {
+		produceTestBrokerMessage(t, broker, key)
+	}
+
+	lastOffsets := make(map[string]int)
+	for range keys {
+		msg := receiveTestMessage(t, consumer)
+		position := getTestPosition(t, msg)
+
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(msg.Message.Key))
+		if expected := int(hash.Sum32() % partitions); position.Partition != expected {
+			t.Fatalf("message with key %s in partition %d, expected %d", msg.Message.Key, position.Partition, expected)
+		}
+
+		// the messages with the same key are delivered in the produced order
+		if lastOffset, ok := lastOffsets[msg.Message.Key]; ok && position.Offset <= lastOffset {
+			t.Fatalf("message with key %s delivered out of order", msg.Message.Key)
+		}
+		lastOffsets[msg.Message.Key] = position.Offset
+	}
+	if len(lastOffsets) != 3 {
+		t.Fatalf("received keys %v, expected all of %v", lastOffsets, keys)
+	}
+}
+
+func TestInMemoryBrokerCommitOffsetRedeliversUncommitted(t *testing.T) {
+	broker := NewInMemoryBroker()
+	view := broker.CommitOffsetBroker()
+	consumer := openTestConsumer(t, view, "subscriber")
+
+	ids := []uuid.UUID{
+		produceTestBrokerMessage(t, broker, ""),
+		produceTestBrokerMessage(t, broker, ""),
+		produceTestBrokerMessage(t, broker, ""),
+	}
+
+	received := make([]*ConsumerMessage, 0, len(ids))
+	for range ids {
+		received = append(received, receiveTestMessage(t, consumer))
+	}
+
+	// the later offset commits the earlier ones as well
+	err := consumer.Acknowledge().CommitOffset(context.Background(), received[1])
+	if err != nil {
+		t.Fatalf("commit offset: %v", err)
+	}
+	_ = consumer.Close()
+
+	consumer = openTestConsumer(t, view, "subscriber")
+	expectTestMessage(t, receiveTestMessage(t, consumer), ids[2])
+}
+
+func TestInMemoryBrokerAckNackRedeliversNacked(t *testing.T) {
+	broker := NewInMemoryBroker()
+	view := broker.AckNackBroker()
+	consumer := openTestConsumer(t, view, "subscriber")
+
+	ids := []uuid.UUID{
+		produceTestBrokerMessage(t, broker, ""),
+		produceTestBrokerMessage(t, broker, ""),
+		produceTestBrokerMessage(t, broker, ""),
+	}
+
+	acked := receiveTestMessage(t, consumer)
+	expectTestMessage(t, acked, ids[0])
+	nacked := receiveTestMessage(t, consumer)
+	expectTestMessage(t, nacked, ids[1])
+
+	err := consumer.Acknowledge().Ack(context.Background(), acked)
+	if err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	nacked.Attempts = 3
+	err = consumer.Acknowledge().Nack(context.Background(), nacked, errors.New("handler failed"))
+	if err != nil {
+		t.Fatalf("nack: %v", err)
+	}
+
+	// the nacked message is redelivered with the attempts reported by the listener,
+	// the next message could be already fetched before it
+	var redelivered *ConsumerMessage
+	for range 2 {
+		msg := receiveTestMessage(t, consumer)
+		if msg.Message.ID == ids[1] {
+			redelivered = msg
+		}
+	}
+	if redelivered == nil {
+		t.Fatalf("message %v wasn't redelivered", ids[1])
+	}
+	if redelivered.Attempts != 3 || redelivered.FirstFailedAt == nil {
+		t.Fatalf("redelivered with %d attempts, first failed at %v", redelivered.Attempts, redelivered.FirstFailedAt)
+	}
+
+	// the messages pending on close are delivered to the next consumer of the subscriber
+	_ = consumer.Close()
+
+	consumer = openTestConsumer(t, view, "subscriber")
+	pending := map[uuid.UUID]bool{ids[1]: true, ids[2]: true}
+	for range len(pending) {
+		msg := receiveTestMessage(t, consumer)
+		if !pending[msg.Message.ID] {
+			t.Fatalf("unexpected message %v redelivered", msg.Message.ID)
+		}
+		delete(pending, msg.Message.ID)
+	}
+}
+
+func TestInMemoryBrokerSubscribersHaveIndependentOffsets(t *testing.T) {
+	broker := NewInMemoryBroker()
+	view := broker.AckNackBroker()
+	first := openTestConsumer(t, view, "first")
+	second := openTestConsumer(t, view, "second")
+
+	_, err := view.Consumer(testBrokerTopic, "first")
+	if err == nil {
+		t.Fatal("second consumer of the same subscriber is created")
+	}
+
+	id := produceTestBrokerMessage(t, broker, "")
+	msg := receiveTestMessage(t, first)
+	expectTestMessage(t, msg, id)
+	err = first.Acknowledge().Ack(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+
+	expectTestMessage(t, receiveTestMessage(t, second), id)
+}
+
+func openTestConsumer[S AcknowledgeStrategy](t *testing.T, broker Broker[S], subscriber Subscriber) Consumer[S] {
+	t.Helper()
+
+	consumer, err := broker.Consumer(testBrokerTopic, subscriber)
+	if err != nil {
+		t.Fatalf("create consumer: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = consumer.Close()
+	})
+
+	return consumer
+}
+
+func produceTestBrokerMessage(t *testing.T, broker *InMemoryBroker, key string) uuid.UUID {
+	t.Helper()
+
+	id := uuid.New()
+	err := broker.Produce(context.Background(), &Message{
+		ID:      id,
+		Topic:   testBrokerTopic,
+		Key:     key,
+		Payload: nil,
+		Headers: nil,
+	})
+	if err != nil {
+		t.Fatalf("produce message: %v", err)
+	}
+
+	return id
+}
+
+func receiveTestMessage[S AcknowledgeStrategy](t *testing.T, consumer Consumer[S]) *ConsumerMessage {
+	t.Helper()
+
+	select {
+	case msg, ok := <-consumer.Messages():
+		if !ok {
+			t.Fatal("consumer messages channel closed")
+		}
+		return msg
+	case <-time.After(testBrokerTimeout):
+		t.Fatal("message wasn't received")
+		return nil
+	}
+}
+
+func expectTestMessage(t *testing.T, msg *ConsumerMessage, id uuid.UUID) {
+	t.Helper()
+
+	if msg.Message.ID != id {
+		t.Fatalf("received message %v, expected %v", msg.Message.ID, id)
+	}
+}
+
+func getTestPosition(t *testing.T, msg *ConsumerMessage) inMemoryPosition {
+	t.Helper()
+
+	position, ok := msg.Context.Value(inMemoryPositionContextKey).(inMemoryPosition)
+	if !ok {
+		t.Fatalf("message %v has no in-memory position", msg.Message.ID)
+	}
+
+	return position
+}
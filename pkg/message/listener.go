@@ -21,12 +21,18 @@ import (
 
 const defaultWorkersCount = 1
 
+// ErrHandlerTimeout is returned when the handler attempt exceeded its timeout, the attempt is retried as failed one
+var ErrHandlerTimeout = errors.New("handler timed out")
+
 type (
 	ListenerImpl struct {
 		// MaxProcessedMessages is the max number of simultaneously processed messages
-		MaxProcessedMessages     int
-		Middlewares              []HandlerMiddleware
-		HandlerRetry             backoff.BackOff
+		MaxProcessedMessages int
+		// KeyOrdered processes messages with the same Message.Key sequentially, different keys are processed in parallel
+		KeyOrdered  bool
+		Middlewares []HandlerMiddleware
+		// HandlerRetry creates the retry backoff for every handled message, the backoff is stateful so it isn't shared
+		HandlerRetry             func() backoff.BackOff
 		Workers                  worker.Pool
 		OnHandlerNotFound        []func(context.Context, *Message)
 		OnBeforeHandleMessage    []func(context.Context, *Message) context.Context
@@ -34,12 +40,41 @@ type (
 		OnAcknowledgeResult      []func(_ context.Context, _ *Message, handlerResult error, ackErr error)
 		OnDeserializedUnknownMsg []func(context.Context, *Message, error)
 		OnDeserializedError      []func(context.Context, *Message, error)
+		PayloadDecoders          []PayloadDecoder
+		DeadLetterProducer       Producer
+		DeadLetterPolicy         DeadLetterPolicy
+		OnDeadLetter             []func(_ context.Context, _ *Message, reason error, produceErr error)
+		// HandlerTimeout limits every handler attempt, zero value means no limit
+		HandlerTimeout time.Duration
+		// HandlerTimeouts overrides HandlerTimeout for the message types, see WithMessageTimeout
+		HandlerTimeouts map[string]time.Duration
+		// ConsumeLimiters are waited before pulling every next message, see WithHandlerRateLimit and WithHandlerCircuitBreaker
+		ConsumeLimiters      []ConsumeLimiter
+		OnCircuitStateChange []func(context.Context, Subscriber, Topic, CircuitState)
+		// DrainTimeout limits waiting for the in-flight messages on stop, zero value means waiting
+		// until the worker.ShutdownContext is canceled
+		DrainTimeout time.Duration
+		// Filters are applied to the Message.Headers before the deserialization, see WithHandlerFilter
+		Filters           []MessageFilter
+		OnMessageFiltered []func(context.Context, *Message)
 
 		consumer     Consumer[any]
 		handlers     map[string][]TypedHandler[StructuredMessage]
 		deserializer Deserializer
 		queue        ListenerProcessingQueue
 		queueRetry   backoff.BackOff
+		keyQueues    *keyOrderedQueues
+	}
+
+	// handlerAttempts is the failed attempts state of the message handler
+	handlerAttempts struct {
+		Count         int
+		FirstFailedAt *time.Time
+	}
+
+	keyOrderedQueues struct {
+		mutex  *sync.Mutex
+		queues map[string][]*ConsumerMessage
 	}
 
 	ListenerQueueBuilder[S AcknowledgeStrategy] func(ackStrategy S, queueSize int) ListenerProcessingQueue
@@ -55,12 +90,14 @@ func NewListener[S AcknowledgeStrategy](
 	deserializer Deserializer,
 	opts ...ListenerOption,
 ) worker.ContextJob {
-	defaultHandlerRetry := backoff.NewExponentialBackOff(
-		backoff.WithInitialInterval(100*time.Millisecond),
-		backoff.WithMultiplier(2),
-		backoff.WithMaxInterval(5*time.Minute),
-		backoff.WithMaxElapsedTime(0),
-	)
+	defaultHandlerRetry := func() backoff.BackOff {
+		return backoff.NewExponentialBackOff(
+			backoff.WithInitialInterval(100*time.Millisecond),
+			backoff.WithMultiplier(2),
+			backoff.WithMaxInterval(5*time.Minute),
+			backoff.WithMaxElapsedTime(0),
+		)
+	}
 
 	defaultQueueRetry := backoff.NewExponentialBackOff(
 		backoff.WithInitialInterval(100*time.Millisecond),
@@ -71,6 +108,7 @@ func NewListener[S AcknowledgeStrategy](
 
 	impl := &ListenerImpl{
 		MaxProcessedMessages:     defaultWorkersCount,
+		KeyOrdered:               false,
 		Middlewares:              nil,
 		HandlerRetry:             defaultHandlerRetry,
 		Workers:                  worker.NewPool(defaultWorkersCount),
@@ -80,19 +118,40 @@ func NewListener[S AcknowledgeStrategy](
 		OnAcknowledgeResult:      nil,
 		OnDeserializedUnknownMsg: nil,
 		OnDeserializedError:      nil,
+		PayloadDecoders:          nil,
+		DeadLetterProducer:       nil,
+		DeadLetterPolicy:         DeadLetterPolicy{},
+		OnDeadLetter:             nil,
+		HandlerTimeout:           0,
+		HandlerTimeouts:          make(map[string]time.Duration),
+		ConsumeLimiters:          nil,
+		OnCircuitStateChange:     nil,
+		DrainTimeout:             0,
+		Filters:                  nil,
+		OnMessageFiltered:        nil,
 
 		consumer:     consumerAdapter[S]{consumer},
 		handlers:     messageHandlers,
 		deserializer: deserializer,
 		queueRetry:   defaultQueueRetry,
+		keyQueues: &keyOrderedQueues{
+			mutex:  &sync.Mutex{},
+			queues: make(map[string][]*ConsumerMessage),
+		},
 	}
 	for _, opt := range opts {
 		opt(impl)
 	}
 
 	for msgType, handlers := range impl.handlers {
+		timeout := impl.HandlerTimeout
+		if typeTimeout, ok := impl.HandlerTimeouts[msgType]; ok {
+			timeout = typeTimeout
+		}
+
 		for i := range handlers {
 			handlers[i] = impl.wrapWithPanicHandler(handlers[i])
+			handlers[i] = impl.wrapWithTimeout(handlers[i], timeout)
 			for j := len(impl.Middlewares) - 1; j >= 0; j-- {
 				handlers[i] = impl.Middlewares[j](handlers[i])
 			}
@@ -130,16 +189,64 @@ func (l *ListenerImpl) wrapWithPanicHandler(handler TypedHandler[StructuredMessa
 	}
 }
 
+// wrapWithTimeout limits the handler attempt by the context deadline and waits for the handler to return,
+// so the next retry never runs alongside the timed out attempt. The handler must respect the context cancellation,
+// otherwise it holds the processing slot
+func (l *ListenerImpl) wrapWithTimeout(handler TypedHandler[StructuredMessage], timeout time.Duration) TypedHandler[StructuredMessage] {
+	if timeout <= 0 {
+		return handler
+	}
+
+	return func(ctx context.Context, msg StructuredMessage) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		err := handler(ctx, msg)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w after %v: %w", ErrHandlerTimeout, timeout, err)
+		}
+
+		return err
+	}
+}
+
 func (l *ListenerImpl) consumerWorker(ctx context.Context) error {
+	// drainCtx is canceled only after the DrainTimeout since the stop, so acknowledgements work until then
+	drainCtx, cancelDrain := context.WithCancel(worker.ShutdownContext(ctx))
+	defer cancelDrain()
+
 	err := func() error {
 		wg := &sync.WaitGroup{}
-		defer wg.Wait()
+		drain := func() error {
+			if l.DrainTimeout > 0 {
+				timer := time.AfterFunc(l.DrainTimeout, cancelDrain)
+				defer timer.Stop()
+			}
+
+			drained := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(drained)
+			}()
+
+			select {
+			case <-drained:
+			case <-drainCtx.Done():
+			}
+			return l.consumer.Close()
+		}
 
 		for {
 			select {
 			case <-l.queue.ProcessingTokens():
 			case <-ctx.Done():
-				return l.consumer.Close()
+				return drain()
+			}
+
+			for _, limiter := range l.ConsumeLimiters {
+				if err := limiter.Wait(ctx); err != nil {
+					return drain()
+				}
 			}
 
 			select {
@@ -147,14 +254,15 @@ func (l *ListenerImpl) consumerWorker(ctx context.Context) error {
 				if !ok {
 					return errors.New("consumer closed messages channel")
 				}
+				msg.Context = withReceivedAt(msg.Context, time.Now())
 				if err := l.queue.AddProcessing(msg); err != nil {
 					return fmt.Errorf("add to processing internal error: %w", err)
 				}
 
 				wg.Add(1)
-				go l.processMessage(ctx, msg, wg)
+				l.dispatchMessage(ctx, drainCtx, msg, wg)
 			case <-ctx.Done():
-				return l.consumer.Close()
+				return drain()
 			}
 		}
 	}()
@@ -165,36 +273,75 @@ func (l *ListenerImpl) consumerWorker(ctx context.Context) error {
 	return nil
 }
 
-func (l *ListenerImpl) processMessage(ctx context.Context, msg *ConsumerMessage, processing *sync.WaitGroup) {
-	skipAndAckMessage := func(ctx context.Context, msg *ConsumerMessage) {
+func (l *ListenerImpl) dispatchMessage(ctx, drainCtx context.Context, msg *ConsumerMessage, processing *sync.WaitGroup) {
+	key := msg.Message.Key
+	if !l.KeyOrdered || key == "" {
+		go l.processMessage(ctx, drainCtx, msg, processing)
+		return
+	}
+
+	l.keyQueues.mutex.Lock()
+	defer l.keyQueues.mutex.Unlock()
+
+	if queue, ok := l.keyQueues.queues[key]; ok {
+		l.keyQueues.queues[key] = append(queue, msg)
+		return
+	}
+	l.keyQueues.queues[key] = nil
+
+	go func() {
 		for {
-			select {
-			case <-ctx.Done():
+			l.processMessage(ctx, drainCtx, msg, processing)
+
+			l.keyQueues.mutex.Lock()
+			queue := l.keyQueues.queues[key]
+			if len(queue) == 0 {
+				delete(l.keyQueues.queues, key)
+				l.keyQueues.mutex.Unlock()
 				return
-			default:
 			}
 
-			if err := l.acknowledgeMessage(ctx, ctx, msg, nil); err == nil {
-				break
-			}
+			msg = queue[0]
+			l.keyQueues.queues[key] = queue[1:]
+			l.keyQueues.mutex.Unlock()
 		}
-	}
+	}()
+}
 
+// processMessage stops handling on the ctx cancellation, but acknowledges the handled message within the drainCtx
+func (l *ListenerImpl) processMessage(ctx, drainCtx context.Context, msg *ConsumerMessage, processing *sync.WaitGroup) {
 	defer processing.Done()
 
-	msgImpl, meta, err := l.deserializer.Deserialize(msg.Message.Payload)
+	// the message waiting in the key ordered queue is not handled on stop, so it's redelivered
+	if ctx.Err() != nil {
+		return
+	}
+
+	if !l.matchFilters(&msg.Message) {
+		for _, fn := range l.OnMessageFiltered {
+			fn(ctx, &msg.Message)
+		}
+		l.skipAndAckMessage(drainCtx, drainCtx, msg)
+		return
+	}
+
+	msgImpl, meta, err := l.deserialize(msg.Message.Payload)
 	if errors.Is(err, ErrDeserializeUnknownMessage) {
 		for _, fn := range l.OnDeserializedUnknownMsg {
 			fn(ctx, &msg.Message, err)
 		}
-		skipAndAckMessage(ctx, msg)
+		l.skipMessage(drainCtx, msg, err)
 		return
 	}
 	if err != nil {
 		for _, fn := range l.OnDeserializedError {
 			fn(ctx, &msg.Message, err)
 		}
-		skipAndAckMessage(ctx, msg)
+		// the queues not supporting the negative acknowledgement can't redeliver the message, so it's skipped
+		if errors.Is(err, ErrDeserializeNewerVersion) && l.nackMessage(drainCtx, drainCtx, msg, err) {
+			return
+		}
+		l.skipMessage(drainCtx, msg, err)
 		return
 	}
 
@@ -203,7 +350,7 @@ func (l *ListenerImpl) processMessage(ctx context.Context, msg *ConsumerMessage,
 		for _, fn := range l.OnHandlerNotFound {
 			fn(ctx, &msg.Message)
 		}
-		skipAndAckMessage(ctx, msg)
+		l.skipAndAckMessage(drainCtx, drainCtx, msg)
 		return
 	}
 
@@ -212,6 +359,12 @@ func (l *ListenerImpl) processMessage(ctx context.Context, msg *ConsumerMessage,
 		msgCtx = fn(msgCtx, &msg.Message)
 	}
 
+	// the failed deliveries tracked by the broker are counted by the DeadLetterPolicy as well
+	attempts := make([]handlerAttempts, len(handlers))
+	for i := range attempts {
+		attempts[i] = handlerAttempts{Count: msg.Attempts, FirstFailedAt: msg.FirstFailedAt}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -220,12 +373,9 @@ func (l *ListenerImpl) processMessage(ctx context.Context, msg *ConsumerMessage,
 		}
 
 		_, handlersGroup := worker.WithinGroup(msgCtx, l.Workers)
-		for _, handler := range handlers {
+		for i, handler := range handlers {
 			handlersGroup.Do(func() error {
-				return backoff.Retry(
-					func() error { return handler(msgCtx, msgImpl) },
-					backoff.WithContext(l.HandlerRetry, ctx),
-				)
+				return l.handleWithRetry(ctx, msgCtx, handler, msgImpl, &attempts[i])
 			})
 		}
 
@@ -234,12 +384,165 @@ func (l *ListenerImpl) processMessage(ctx context.Context, msg *ConsumerMessage,
 			fn(msgCtx, &msg.Message, handlerErr)
 		}
 
-		if err = l.acknowledgeMessage(ctx, msgCtx, msg, handlerErr); err == nil {
+		if exhaustedErr, ok := getHandlerAttemptsExhausted(handlerErr); ok && l.DeadLetterProducer != nil {
+			l.deadLetterAndAckMessage(drainCtx, msgCtx, msg, meta, exhaustedErr.Attempts, exhaustedErr.Err)
+			return
+		}
+
+		if handlerErr != nil {
+			updateMessageAttempts(msg, attempts)
+		}
+		if err = l.acknowledgeMessage(drainCtx, msgCtx, msg, handlerErr); err == nil {
 			break
 		}
 	}
 }
 
+// updateMessageAttempts passes the handler attempts to the broker negatively acknowledging the message,
+// so the next delivery continues counting them
+func updateMessageAttempts(msg *ConsumerMessage, attempts []handlerAttempts) {
+	for _, handler := range attempts {
+		msg.Attempts = max(msg.Attempts, handler.Count)
+		if handler.FirstFailedAt != nil && (msg.FirstFailedAt == nil || handler.FirstFailedAt.Before(*msg.FirstFailedAt)) {
+			msg.FirstFailedAt = handler.FirstFailedAt
+		}
+	}
+}
+
+func (l *ListenerImpl) deserialize(payload []byte) (StructuredMessage, Metadata, error) {
+	var err error
+	for i := len(l.PayloadDecoders) - 1; i >= 0; i-- {
+		payload, err = l.PayloadDecoders[i](payload)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decode payload: %w", err)
+		}
+	}
+
+	return l.deserializer.Deserialize(payload)
+}
+
+// handleWithRetry returns handlerAttemptsExhaustedError only if the DeadLetterPolicy is exceeded, the error
+// of the stopped retries is returned as is, so the message is negatively acknowledged to be redelivered
+func (l *ListenerImpl) handleWithRetry(
+	ctx, msgCtx context.Context,
+	handler TypedHandler[StructuredMessage],
+	msg StructuredMessage,
+	attempts *handlerAttempts,
+) error {
+	var lastErr error
+	err := backoff.Retry(
+		func() error {
+			err := handler(msgCtx, msg)
+			lastErr = err
+			if err == nil {
+				return nil
+			}
+
+			attempts.Count++
+			if attempts.FirstFailedAt == nil {
+				now := time.Now()
+				attempts.FirstFailedAt = &now
+			}
+			if l.DeadLetterProducer != nil && l.DeadLetterPolicy.Exceeded(attempts.Count, time.Since(*attempts.FirstFailedAt)) {
+				return backoff.Permanent(&handlerAttemptsExhaustedError{Err: err, Attempts: attempts.Count})
+			}
+
+			return err
+		},
+		backoff.WithContext(l.HandlerRetry(), ctx),
+	)
+	if err == nil {
+		return nil
+	}
+	if _, ok := getHandlerAttemptsExhausted(err); ok {
+		return err
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+
+	return err
+}
+
+func (l *ListenerImpl) skipMessage(ctx context.Context, msg *ConsumerMessage, reason error) {
+	if l.DeadLetterProducer == nil {
+		l.skipAndAckMessage(ctx, ctx, msg)
+		return
+	}
+
+	l.deadLetterAndAckMessage(ctx, ctx, msg, nil, 1, reason)
+}
+
+func (l *ListenerImpl) skipAndAckMessage(ctx, msgCtx context.Context, msg *ConsumerMessage) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if err := l.acknowledgeMessage(ctx, msgCtx, msg, nil); err == nil {
+			return
+		}
+	}
+}
+
+func (l *ListenerImpl) deadLetterAndAckMessage(
+	ctx, msgCtx context.Context,
+	msg *ConsumerMessage,
+	meta Metadata,
+	attempts int,
+	reason error,
+) {
+	deadLetterMsg, err := newDeadLetterMessage(&msg.Message, l.consumer.Subscriber(), meta, attempts, reason)
+	if err != nil {
+		for _, fn := range l.OnDeadLetter {
+			fn(msgCtx, &msg.Message, reason, err)
+		}
+		if !l.nackMessage(ctx, msgCtx, msg, fmt.Errorf("create dead-letter message: %w", err)) {
+			l.skipAndAckMessage(ctx, msgCtx, msg)
+		}
+		return
+	}
+
+	err = backoff.Retry(
+		func() error {
+			err := l.DeadLetterProducer.Produce(msgCtx, deadLetterMsg)
+			for _, fn := range l.OnDeadLetter {
+				fn(msgCtx, &msg.Message, reason, err)
+			}
+
+			return err
+		},
+		backoff.WithContext(l.queueRetry, ctx),
+	)
+	if err != nil {
+		return
+	}
+
+	l.skipAndAckMessage(ctx, msgCtx, msg)
+}
+
+// nackMessage negatively acknowledges the message to be redelivered, returns false if the queue doesn't support it,
+// so the caller must release the message processing slot otherwise
+func (l *ListenerImpl) nackMessage(ctx, msgCtx context.Context, msg *ConsumerMessage, reason error) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		default:
+		}
+
+		err := l.acknowledgeMessage(ctx, msgCtx, msg, reason)
+		if errors.Is(err, ErrNegativeAckNotSupported) {
+			return false
+		}
+		if err == nil {
+			return true
+		}
+	}
+}
+
 func (l *ListenerImpl) acknowledgeMessage(ctx, msgCtx context.Context, msg *ConsumerMessage, handlerErr error) error {
 	return backoff.Retry(
 		func() error {
@@ -296,9 +599,11 @@ func WithHandlerLogging(logger log.Logger, infoLevel, errorLevel log.Level) List
 
 			logger := logger.With(log.Fields{
 				"handledMessage": log.Fields{
-					"id":    meta.MessageID,
-					"type":  msg.Type(),
-					"topic": meta.MessageTopic,
+					"id":            meta.MessageID,
+					"type":          msg.Type(),
+					"topic":         meta.MessageTopic,
+					"correlationID": meta.CorrelationID,
+					"causationID":   meta.CausationID,
 				},
 			})
 			if meta.Panic != nil {
@@ -308,6 +613,10 @@ func WithHandlerLogging(logger log.Logger, infoLevel, errorLevel log.Level) List
 				}).Error(ctx, "message handled with panic")
 				return err
 			}
+			if errors.Is(err, ErrHandlerTimeout) {
+				logger.WithError(err).Log(ctx, errorLevel, "message handler timed out")
+				return err
+			}
 			if err != nil {
 				logger.WithError(err).Log(ctx, errorLevel, "message handled with error")
 				return err
@@ -372,6 +681,28 @@ func WithHandlerLogging(logger log.Logger, infoLevel, errorLevel log.Level) List
 				WithError(err).
 				Log(ctx, errorLevel, "failed to deserialize message")
 		})
+
+		l.OnCircuitStateChange = append(l.OnCircuitStateChange, func(ctx context.Context, subscriber Subscriber, topic Topic, state CircuitState) {
+			logger.With(log.Fields{
+				"subscriber":   subscriber,
+				"topic":        topic,
+				"circuitState": state.String(),
+			}).Log(ctx, errorLevel, "message consuming circuit state changed")
+		})
+
+		l.OnDeadLetter = append(l.OnDeadLetter, func(ctx context.Context, msg *Message, reason, err error) {
+			logger := logger.With(log.Fields{
+				"messageID":        msg.ID,
+				"topic":            msg.Topic,
+				"deadLetterReason": reason.Error(),
+			})
+			if err != nil {
+				logger.WithError(err).Log(ctx, errorLevel, "failed to produce message to dead-letter topic")
+				return
+			}
+
+			logger.Log(ctx, errorLevel, "message moved to dead-letter topic")
+		})
 	}
 }
 
@@ -388,18 +719,53 @@ func WithHandlerMetrics(metrics metric.Metrics) ListenerOption {
 					"type":  msg.Type(),
 				}).Increment("msg_handle_panics_total")
 			}
+			if errors.Is(err, ErrHandlerTimeout) {
+				metrics.With(metric.Labels{
+					"topic": meta.MessageTopic,
+					"type":  msg.Type(),
+				}).Increment("msg_handle_timeouts_total")
+			}
 
 			metrics.With(metric.Labels{
 				"topic":   meta.MessageTopic,
 				"type":    msg.Type(),
 				"success": err == nil,
 			}).Duration("msg_handle_duration_seconds", time.Since(started))
+
+			if producedAt, ok := GetMessageProducedAt(meta.MessageMetadata); ok {
+				metrics.With(metric.Labels{
+					"topic":   meta.MessageTopic,
+					"type":    msg.Type(),
+					"success": err == nil,
+				}).Duration("msg_end_to_end_latency_seconds", time.Since(producedAt))
+			}
 			return err
 		}
 	}
 
 	return func(l *ListenerImpl) {
 		l.Middlewares = append(l.Middlewares, mw)
+
+		l.OnDeadLetter = append(l.OnDeadLetter, func(_ context.Context, msg *Message, _, err error) {
+			metrics.With(metric.Labels{
+				"topic":   msg.Topic,
+				"success": err == nil,
+			}).Increment("msg_dead_letter_produce_attempts_total")
+		})
+
+		l.OnMessageFiltered = append(l.OnMessageFiltered, func(_ context.Context, msg *Message) {
+			metrics.With(metric.Labels{
+				"topic": msg.Topic,
+				"type":  msg.Headers[TypeHeader],
+			}).Increment("msg_filtered_total")
+		})
+
+		l.OnCircuitStateChange = append(l.OnCircuitStateChange, func(_ context.Context, subscriber Subscriber, topic Topic, state CircuitState) {
+			metrics.With(metric.Labels{
+				"subscriber": subscriber,
+				"topic":      topic,
+			}).Gauge("msg_consume_circuit_state", int(state))
+		})
 	}
 }
 
@@ -427,6 +793,50 @@ func WithHandlerObservability(observer observability.Observer, fields ...observa
 	}
 }
 
+// WithHandlerTimeout limits every handler attempt, override it for the message type with WithMessageTimeout
+func WithHandlerTimeout(timeout time.Duration) ListenerOption {
+	return func(l *ListenerImpl) {
+		l.HandlerTimeout = timeout
+	}
+}
+
+// WithHandlerDrainTimeout limits waiting for the in-flight messages on the listener stop,
+// the messages not acknowledged in time are redelivered
+func WithHandlerDrainTimeout(timeout time.Duration) ListenerOption {
+	return func(l *ListenerImpl) {
+		l.DrainTimeout = timeout
+	}
+}
+
+func withHandlerMessageTypeTimeout(msgType string, timeout time.Duration) ListenerOption {
+	return func(l *ListenerImpl) {
+		if l.HandlerTimeouts == nil {
+			l.HandlerTimeouts = make(map[string]time.Duration)
+		}
+		l.HandlerTimeouts[msgType] = timeout
+	}
+}
+
+// withHandlerBatchSize raises the number of simultaneously processed messages to fill the batch,
+// the worker pool is replaced if it's smaller than the batch
+func withHandlerBatchSize(size int) ListenerOption {
+	return func(l *ListenerImpl) {
+		if l.MaxProcessedMessages >= size {
+			return
+		}
+
+		l.Workers = worker.NewPool(size)
+		l.MaxProcessedMessages = size
+	}
+}
+
+// WithHandlerPayloadCodec decodes payloads encoded by WithBusProducerPayloadCodec, plain payloads are passed as is
+func WithHandlerPayloadCodec(codec *PayloadCodec) ListenerOption {
+	return func(l *ListenerImpl) {
+		l.PayloadDecoders = append(l.PayloadDecoders, codec.Decode)
+	}
+}
+
 func WithHandlerMultipleWorkers(workersCount int) ListenerOption {
 	if workersCount <= worker.MaxWorkersCountNumCPU {
 		workersCount = runtime.NumCPU()
@@ -448,12 +858,28 @@ func WithHandlerWorkerPool(pool worker.Pool, maxProcessedMessagesLimit int) List
 	}
 }
 
-func WithHandlerRetry(retry backoff.BackOff) ListenerOption {
+func WithHandlerKeyOrdering() ListenerOption {
+	return func(l *ListenerImpl) {
+		l.KeyOrdered = true
+	}
+}
+
+// WithHandlerRetry sets the retry backoff factory, it's called for every handled message
+func WithHandlerRetry(retry func() backoff.BackOff) ListenerOption {
 	return func(l *ListenerImpl) {
 		l.HandlerRetry = retry
 	}
 }
 
+// WithHandlerDeadLetter stops retrying the failed handler after the policy is exceeded and produces the message
+// to the dead-letter topic, then the original message is acknowledged. Messages failed to deserialize go there as well
+func WithHandlerDeadLetter(producer Producer, policy DeadLetterPolicy) ListenerOption {
+	return func(l *ListenerImpl) {
+		l.DeadLetterProducer = producer
+		l.DeadLetterPolicy = policy
+	}
+}
+
 type consumerAdapter[S AcknowledgeStrategy] struct {
 	Consumer[S]
 }
@@ -0,0 +1,303 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/google/uuid"
+
+	"github.com/klwxsrx/go-service-template/pkg/worker"
+)
+
+const (
+	testListenerTopic      Topic      = "test.listener"
+	testListenerSubscriber Subscriber = "test-subscriber"
+	testListenerTimeout               = 5 * time.Second
+	testMessageType                   = "test_message"
+)
+
+var errTestHandler = errors.New("test handler failed")
+
+type (
+	testMessage struct {
+		MessageID uuid.UUID `json:"id"`
+	}
+
+	testMessageV2 struct {
+		testMessage
+	}
+)
+
+func (m testMessage) ID() uuid.UUID {
+	return m.MessageID
+}
+
+func (m testMessage) Type() string {
+	return testMessageType
+}
+
+func (m testMessageV2) Version() int {
+	return 2
+}
+
+func TestListenerSkipsNewerVersionWithoutNackSupport(t *testing.T) {
+	broker := NewInMemoryBroker().CommitOffsetBroker()
+
+	handled := make(chan uuid.UUID, 1)
+	stop := runTestListener(t, broker, NewCommitOffsetQueue, func(_ context.Context, msg StructuredMessage) error {
+		handled <- msg.ID()
+		return nil
+	})
+
+	newer := testMessageV2{testMessage{MessageID: uuid.New()}}
+	produceTestMessage(t, broker, newer)
+	current := testMessage{MessageID: uuid.New()}
+	produceTestMessage(t, broker, current)
+
+	// the only processing slot is released by the skipped message, otherwise the next one is never pulled
+	expectHandled(t, handled, current.ID())
+	stop()
+
+	next := testMessage{MessageID: uuid.New()}
+	produceTestMessage(t, broker, next)
+	expectNextConsumed(t, broker, next.ID())
+}
+
+func TestListenerSkipsNewerVersionOnAckQueue(t *testing.T) {
+	broker := NewInMemoryBroker().AckNackBroker()
+	ackQueue := func(ack AckNackStrategy, maxSize int) ListenerProcessingQueue {
+		return NewAckQueue(ack, maxSize)
+	}
+
+	handled := make(chan uuid.UUID, 1)
+	stop := runTestListener(t, broker, ackQueue, func(_ context.Context, msg StructuredMessage) error {
+		handled <- msg.ID()
+		return nil
+	})
+
+	newer := testMessageV2{testMessage{MessageID: uuid.New()}}
+	produceTestMessage(t, broker, newer)
+	current := testMessage{MessageID: uuid.New()}
+	produceTestMessage(t, broker, current)
+
+	expectHandled(t, handled, current.ID())
+	stop()
+
+	next := testMessage{MessageID: uuid.New()}
+	produceTestMessage(t, broker, next)
+	expectNextConsumed(t, broker, next.ID())
+}
+
+func TestListenerDeadLettersAfterPolicyAttemptsOfAllDeliveries(t *testing.T) {
+	inMemoryBroker := NewInMemoryBroker()
+	broker := inMemoryBroker.AckNackBroker()
+
+	const maxAttempts = 5
+	var calls atomic.Int32
+	stop := runTestListener(t, broker, NewAckNackQueue, func(context.Context, StructuredMessage) error {
+		calls.Add(1)
+		return errTestHandler
+	},
+		// every delivery is negatively acknowledged after two attempts, so the policy counts the redeliveries
+		WithHandlerRetry(func() backoff.BackOff {
+			return backoff.WithMaxRetries(&backoff.ZeroBackOff{}, 1)
+		}),
+		WithHandlerDeadLetter(inMemoryBroker, DeadLetterPolicy{MaxAttempts: maxAttempts, MaxElapsedTime: 0}),
+	)
+
+	failed := testMessage{MessageID: uuid.New()}
+	produceTestMessage(t, broker, failed)
+
+	deadLetter := expectDeadLetter(t, broker, failed.ID())
+	if deadLetter.Attempts != maxAttempts {
+		t.Fatalf("dead letter has %d attempts, expected %d", deadLetter.Attempts, maxAttempts)
+	}
+	stop()
+	if calls.Load() != maxAttempts {
+		t.Fatalf("handler called %d times, expected %d", calls.Load(), maxAttempts)
+	}
+
+	next := testMessage{MessageID: uuid.New()}
+	produceTestMessage(t, broker, next)
+	expectNextConsumed(t, broker, next.ID())
+}
+
+func TestListenerDeadLettersOnCommitOffsetQueue(t *testing.T) {
+	inMemoryBroker := NewInMemoryBroker()
+	broker := inMemoryBroker.CommitOffsetBroker()
+
+	handled := make(chan uuid.UUID, 1)
+	failed := testMessage{MessageID: uuid.New()}
+	stop := runTestListener(t, broker, NewCommitOffsetQueue, func(_ context.Context, msg StructuredMessage) error {
+		if msg.ID() == failed.ID() {
+			return errTestHandler
+		}
+
+		handled <- msg.ID()
+		return nil
+	},
+		// the retries are stopped, but the message can't be negatively acknowledged, so it's retried in process
+		WithHandlerRetry(func() backoff.BackOff {
+			return backoff.WithMaxRetries(&backoff.ZeroBackOff{}, 0)
+		}),
+		WithHandlerDeadLetter(inMemoryBroker, DeadLetterPolicy{MaxAttempts: 3, MaxElapsedTime: 0}),
+	)
+
+	produceTestMessage(t, broker, failed)
+	current := testMessage{MessageID: uuid.New()}
+	produceTestMessage(t, broker, current)
+
+	expectHandled(t, handled, current.ID())
+	deadLetter := expectDeadLetter(t, broker, failed.ID())
+	if deadLetter.Attempts != 3 {
+		t.Fatalf("dead letter has %d attempts, expected %d", deadLetter.Attempts, 3)
+	}
+	stop()
+
+	next := testMessage{MessageID: uuid.New()}
+	produceTestMessage(t, broker, next)
+	expectNextConsumed(t, broker, next.ID())
+}
+
+func runTestListener[S AcknowledgeStrategy](
+	t *testing.T,
+	broker Broker[S],
+	queue ListenerQueueBuilder[S],
+	handler TypedHandler[StructuredMessage],
+	opts ...ListenerOption,
+) (stop func()) {
+	t.Helper()
+
+	consumer, err := broker.Consumer(testListenerTopic, testListenerSubscriber)
+	if err != nil {
+		t.Fatalf("create consumer: %v", err)
+	}
+
+	deserializer := NewJSONSerializer()
+	err = deserializer.RegisterDeserializer(testMessage{}, PayloadDeserializerImpl[testMessage], nil)
+	if err != nil {
+		t.Fatalf("register deserializer: %v", err)
+	}
+
+	listener := NewListener(
+		consumer,
+		map[string][]TypedHandler[StructuredMessage]{testMessageType: {handler}},
+		queue,
+		deserializer,
+		opts...,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- listener(worker.WithShutdownContext(ctx, context.Background()))
+	}()
+
+	var once bool
+	stop = func() {
+		if once {
+			return
+		}
+		once = true
+
+		cancel()
+		select {
+		case err := <-stopped:
+			if err != nil {
+				t.Errorf("listener stopped with error: %v", err)
+			}
+		case <-time.After(testListenerTimeout):
+			t.Fatal("listener wasn't stopped")
+		}
+	}
+	t.Cleanup(stop)
+
+	return stop
+}
+
+func produceTestMessage[S AcknowledgeStrategy](t *testing.T, broker Broker[S], msg StructuredMessage) {
+	t.Helper()
+
+	payload, err := NewJSONSerializer().Serialize(msg, nil)
+	if err != nil {
+		t.Fatalf("serialize message: %v", err)
+	}
+
+	err = broker.Produce(context.Background(), &Message{
+		ID:      msg.ID(),
+		Topic:   testListenerTopic,
+		Key:     "",
+		Payload: payload,
+		Headers: nil,
+	})
+	if err != nil {
+		t.Fatalf("produce message: %v", err)
+	}
+}
+
+func expectHandled(t *testing.T, handled <-chan uuid.UUID, id uuid.UUID) {
+	t.Helper()
+
+	select {
+	case handledID := <-handled:
+		if handledID != id {
+			t.Fatalf("handled message %v, expected %v", handledID, id)
+		}
+	case <-time.After(testListenerTimeout):
+		t.Fatalf("message %v wasn't handled", id)
+	}
+}
+
+func expectDeadLetter[S AcknowledgeStrategy](t *testing.T, broker Broker[S], id uuid.UUID) *DeadLetter {
+	t.Helper()
+
+	consumer, err := broker.Consumer(NewTopicDeadLetter(testListenerTopic), testListenerSubscriber)
+	if err != nil {
+		t.Fatalf("create dead-letter consumer: %v", err)
+	}
+	defer func() {
+		_ = consumer.Close()
+	}()
+
+	select {
+	case msg := <-consumer.Messages():
+		deadLetter, err := DecodeDeadLetter(msg.Message.Payload)
+		if err != nil {
+			t.Fatalf("decode dead letter: %v", err)
+		}
+		if deadLetter.MessageID != id {
+			t.Fatalf("dead-lettered message %v, expected %v", deadLetter.MessageID, id)
+		}
+
+		return deadLetter
+	case <-time.After(testListenerTimeout):
+		t.Fatalf("message %v wasn't dead-lettered", id)
+		return nil
+	}
+}
+
+// expectNextConsumed checks the messages before the id were acknowledged by the stopped listener
+func expectNextConsumed[S AcknowledgeStrategy](t *testing.T, broker Broker[S], id uuid.UUID) {
+	t.Helper()
+
+	consumer, err := broker.Consumer(testListenerTopic, testListenerSubscriber)
+	if err != nil {
+		t.Fatalf("create consumer: %v", err)
+	}
+	defer func() {
+		_ = consumer.Close()
+	}()
+
+	select {
+	case msg := <-consumer.Messages():
+		if msg.Message.ID != id {
+			t.Fatalf("consumed message %v, expected %v", msg.Message.ID, id)
+		}
+	case <-time.After(testListenerTimeout):
+		t.Fatalf("message %v wasn't consumed", id)
+	}
+}
@@ -0,0 +1,203 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+type (
+	// ConsumeLimiter is asked by the listener before pulling every next message from the Consumer
+	ConsumeLimiter interface {
+		Wait(context.Context) error
+	}
+
+	CircuitState int
+
+	// CircuitBreakerConfig opens the circuit when at least FailureRatio of at least MinAttempts handler attempts
+	// within the Window failed. The open circuit pauses consuming for the OpenTimeout, then the single probe message
+	// is consumed, the circuit is closed if it's handled successfully
+	CircuitBreakerConfig struct {
+		FailureRatio float64
+		MinAttempts  int
+		Window       time.Duration
+		OpenTimeout  time.Duration
+	}
+
+	circuitBreaker struct {
+		config         CircuitBreakerConfig
+		onStateChange  func(CircuitState)
+		mutex          *sync.Mutex
+		state          CircuitState
+		stateChanged   chan struct{}
+		windowStarted  time.Time
+		attempts       int
+		failures       int
+		openedAt       time.Time
+		probing        bool
+		probeStartedAt time.Time
+	}
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig, onStateChange func(CircuitState)) *circuitBreaker {
+	return &circuitBreaker{
+		config:         config,
+		onStateChange:  onStateChange,
+		mutex:          &sync.Mutex{},
+		state:          CircuitClosed,
+		stateChanged:   make(chan struct{}),
+		windowStarted:  time.Now(),
+		attempts:       0,
+		failures:       0,
+		openedAt:       time.Time{},
+		probing:        false,
+		probeStartedAt: time.Time{},
+	}
+}
+
+func (b *circuitBreaker) Wait(ctx context.Context) error {
+	for {
+		b.mutex.Lock()
+		var wait <-chan time.Time
+		switch b.state {
+		case CircuitClosed:
+			b.mutex.Unlock()
+			return nil
+		case CircuitOpen:
+			untilHalfOpen := time.Until(b.openedAt.Add(b.config.OpenTimeout))
+			if untilHalfOpen <= 0 {
+				b.setState(CircuitHalfOpen)
+				b.mutex.Unlock()
+				continue
+			}
+			wait = time.After(untilHalfOpen)
+		case CircuitHalfOpen:
+			// the probe message could be skipped without the handler result, so the probe is repeated after the timeout
+			untilNextProbe := time.Until(b.probeStartedAt.Add(b.config.OpenTimeout))
+			if !b.probing || untilNextProbe <= 0 {
+				b.probing = true
+				b.probeStartedAt = time.Now()
+				b.mutex.Unlock()
+				return nil
+			}
+			wait = time.After(untilNextProbe)
+		}
+		stateChanged := b.stateChanged
+		b.mutex.Unlock()
+
+		select {
+		case <-wait:
+		case <-stateChanged:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Record counts the handler attempt of the message received at the specified time. In the half-open state only
+// the probe result is counted, the messages received before the probe were in-flight when the circuit opened
+func (b *circuitBreaker) Record(receivedAt time.Time, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		return
+	case CircuitHalfOpen:
+		if !b.probing || receivedAt.Before(b.probeStartedAt) {
+			return
+		}
+		if err != nil {
+			b.setState(CircuitOpen)
+		} else {
+			b.setState(CircuitClosed)
+		}
+		return
+	case CircuitClosed:
+	}
+
+	if time.Since(b.windowStarted) > b.config.Window {
+		b.windowStarted = time.Now()
+		b.attempts = 0
+		b.failures = 0
+	}
+
+	b.attempts++
+	if err != nil {
+		b.failures++
+	}
+
+	if b.attempts >= b.config.MinAttempts && float64(b.failures)/float64(b.attempts) >= b.config.FailureRatio {
+		b.setState(CircuitOpen)
+	}
+}
+
+// setState must be called under the mutex
+func (b *circuitBreaker) setState(state CircuitState) {
+	b.state = state
+	b.probing = false
+	b.windowStarted = time.Now()
+	b.attempts = 0
+	b.failures = 0
+	if state == CircuitOpen {
+		b.openedAt = time.Now()
+	}
+
+	close(b.stateChanged)
+	b.stateChanged = make(chan struct{})
+
+	if b.onStateChange != nil {
+		b.onStateChange(state)
+	}
+}
+
+// WithHandlerRateLimit limits the rate of the messages pulled from the Consumer by every listener,
+// so it's applied per topic and subscriber
+func WithHandlerRateLimit(perSecond float64, burst int) ListenerOption {
+	return func(l *ListenerImpl) {
+		l.ConsumeLimiters = append(l.ConsumeLimiters, rate.NewLimiter(rate.Limit(perSecond), max(burst, 1)))
+	}
+}
+
+// WithHandlerCircuitBreaker stops pulling the messages from the Consumer while the handlers are failing,
+// see CircuitBreakerConfig. The breaker is created for every listener, so it's applied per topic and subscriber
+func WithHandlerCircuitBreaker(config CircuitBreakerConfig) ListenerOption {
+	return func(l *ListenerImpl) {
+		breaker := newCircuitBreaker(config, func(state CircuitState) {
+			for _, fn := range l.OnCircuitStateChange {
+				fn(context.Background(), l.consumer.Subscriber(), l.consumer.Topic(), state)
+			}
+		})
+
+		l.ConsumeLimiters = append(l.ConsumeLimiters, breaker)
+		l.Middlewares = append(l.Middlewares, func(handler TypedHandler[StructuredMessage]) TypedHandler[StructuredMessage] {
+			return func(ctx context.Context, msg StructuredMessage) error {
+				err := handler(ctx, msg)
+				breaker.Record(GetHandlerMetadata(ctx).ReceivedAt, err)
+				return err
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerCountsProbeResultOnly(t *testing.T) {
+	breaker := newCircuitBreaker(CircuitBreakerConfig{
+		FailureRatio: 1,
+		MinAttempts:  1,
+		Window:       time.Minute,
+		OpenTimeout:  10 * time.Millisecond,
+	}, nil)
+
+	inFlightReceivedAt := time.Now()
+	breaker.Record(time.Now(), errors.New("handler failed"))
+	expectCircuitState(t, breaker, CircuitOpen)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := breaker.Wait(ctx)
+	if err != nil {
+		t.Fatalf("wait for probe: %v", err)
+	}
+	expectCircuitState(t, breaker, CircuitHalfOpen)
+
+	// the message received before the probe was in-flight when the circuit opened
+	breaker.Record(inFlightReceivedAt, nil)
+	expectCircuitState(t, breaker, CircuitHalfOpen)
+
+	breaker.Record(time.Now(), nil)
+	expectCircuitState(t, breaker, CircuitClosed)
+}
+
+func expectCircuitState(t *testing.T, breaker *circuitBreaker, state CircuitState) {
+	t.Helper()
+
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if breaker.state != state {
+		t.Fatalf("circuit is %s, expected %s", breaker.state, state)
+	}
+}
@@ -180,7 +180,7 @@ func (q *ackNackQueue) AcknowledgeResult(ctx context.Context, msg *ConsumerMessa
 			return fmt.Errorf("ack: %w", err)
 		}
 	} else {
-		err := q.acknowledge.Nack(ctx, msg)
+		err := q.acknowledge.Nack(ctx, msg, result)
 		if err != nil {
 			return fmt.Errorf("nack: %w", err)
 		}
@@ -206,6 +206,6 @@ func (q *ackQueue) AcknowledgeResult(ctx context.Context, msg *ConsumerMessage,
 	return q.ListenerProcessingQueue.AcknowledgeResult(ctx, msg, nil)
 }
 
-func (a nackNotSupportedAdapter) Nack(context.Context, *ConsumerMessage) error {
+func (a nackNotSupportedAdapter) Nack(context.Context, *ConsumerMessage, error) error {
 	return ErrNegativeAckNotSupported
 }
@@ -2,10 +2,21 @@ package message
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	// TypeHeader carries StructuredMessage.Type, so consumers could route messages without payload deserialization
+	TypeHeader = "message-type"
+	// VersionHeader carries the message version, see VersionedMessage
+	VersionHeader = "message-version"
+)
+
 type (
 	Message struct {
 		ID    uuid.UUID
@@ -13,6 +24,8 @@ type (
 		// Key is used for topic partitioning, messages with the same key will fall in the same topic partition
 		Key     string
 		Payload []byte
+		// Headers are readable without the payload decoding, see TypeHeader, VersionHeader and RoutedMessage
+		Headers map[string]string
 	}
 
 	StructuredMessage interface {
@@ -20,18 +33,134 @@ type (
 		Type() string
 	}
 
+	// VersionedMessage is implemented by messages which payload schema has evolved, messages without it have version 1
+	VersionedMessage interface {
+		Version() int
+	}
+
+	// DeduplicatedMessage replaces the scheduled message of the topic with the same DeduplicationKey,
+	// see NewBusScheduledProducer
+	DeduplicatedMessage interface {
+		DeduplicationKey() string
+	}
+
+	// RoutedMessage provides the routing attributes copied to the Message.Headers, see WithHandlerFilter
+	RoutedMessage interface {
+		RoutingAttributes() map[string]string
+	}
+
 	TypedHandler[T StructuredMessage] func(context.Context, T) error
 
 	KeyBuilder func(StructuredMessage) string
 
-	RegisterMessageFunc func() (
-		StructuredMessage,
-		KeyBuilder,
-	)
+	RegisterMessageFunc func() RegisteredMessage
+
+	RegisteredMessage struct {
+		Schema     StructuredMessage
+		KeyBuilder KeyBuilder
+		// TTL drops the message not consumed in time after it was scheduled, zero value means no expiry, see WithMessageTTL
+		TTL time.Duration
+	}
+
+	RegisterHandlersFunc func() MessageHandlers
 
-	RegisterHandlersFunc func() (
-		StructuredMessage,
-		PayloadDeserializer,
-		[]TypedHandler[StructuredMessage],
-	)
+	MessageHandlers struct {
+		Schema       StructuredMessage
+		Deserializer PayloadDeserializer
+		Handlers     []TypedHandler[StructuredMessage]
+		// Upcasters deserialize payloads of the older versions to the current Schema struct
+		Upcasters map[int]PayloadDeserializer
+		// Timeout overrides the listener handler timeout for the message type, see WithHandlerTimeout
+		Timeout time.Duration
+		// BatchSize makes the listener process at least BatchSize messages simultaneously, see BatchConfig
+		BatchSize int
+	}
+
+	Upcaster struct {
+		Version      int
+		Deserializer PayloadDeserializer
+	}
 )
+
+func GetMessageVersion(msg StructuredMessage) int {
+	versioned, ok := msg.(VersionedMessage)
+	if !ok || versioned.Version() < 1 {
+		return 1
+	}
+
+	return versioned.Version()
+}
+
+func GetMessageDeduplicationKey(msg StructuredMessage) string {
+	deduplicated, ok := msg.(DeduplicatedMessage)
+	if !ok {
+		return ""
+	}
+
+	return deduplicated.DeduplicationKey()
+}
+
+func getMessageHeaders(msg StructuredMessage) map[string]string {
+	headers := map[string]string{
+		TypeHeader:    msg.Type(),
+		VersionHeader: strconv.Itoa(GetMessageVersion(msg)),
+	}
+
+	routed, ok := msg.(RoutedMessage)
+	if !ok {
+		return headers
+	}
+
+	for key, value := range routed.RoutingAttributes() {
+		if key == TypeHeader || key == VersionHeader {
+			continue
+		}
+		headers[key] = value
+	}
+
+	return headers
+}
+
+// NewUpcaster decodes the payload of the older message version and converts it to the current message struct
+func NewUpcaster[Old any, T StructuredMessage](version int, upcast func(Old) (T, error)) Upcaster {
+	return Upcaster{
+		Version: version,
+		Deserializer: func(payload []byte) (StructuredMessage, error) {
+			var old Old
+			err := json.Unmarshal(payload, &old)
+			if err != nil {
+				return nil, fmt.Errorf("json decode %T: %w", old, err)
+			}
+
+			msg, err := upcast(old)
+			if err != nil {
+				return nil, fmt.Errorf("upcast %T to %T: %w", old, msg, err)
+			}
+
+			return msg, nil
+		},
+	}
+}
+
+// WithMessageTimeout limits every attempt of the message handlers
+func WithMessageTimeout(register RegisterHandlersFunc, timeout time.Duration) RegisterHandlersFunc {
+	return func() MessageHandlers {
+		handlers := register()
+		handlers.Timeout = timeout
+		return handlers
+	}
+}
+
+func WithUpcasters(register RegisterHandlersFunc, upcasters ...Upcaster) RegisterHandlersFunc {
+	return func() MessageHandlers {
+		handlers := register()
+		if handlers.Upcasters == nil {
+			handlers.Upcasters = make(map[int]PayloadDeserializer, len(upcasters))
+		}
+		for _, upcaster := range upcasters {
+			handlers.Upcasters[upcaster.Version] = upcaster.Deserializer
+		}
+
+		return handlers
+	}
+}
@@ -2,22 +2,41 @@ package message
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-const handlerMetaContextKey contextKey = iota
+const (
+	handlerMetaContextKey contextKey = iota
+	inMemoryPositionContextKey
+	producePriorityContextKey
+	produceTTLContextKey
+	receivedAtContextKey
+)
 
-const observabilityMetaKeyPrefix = "observability/"
+const (
+	observabilityMetaKeyPrefix = "observability/"
+	correlationIDMetaKey       = "message/correlation-id"
+	causationIDMetaKey         = "message/causation-id"
+	producedAtMetaKey          = "message/produced-at"
+)
 
 type (
 	Metadata map[string]string
 
 	HandlerMetadata struct {
-		MessageID       uuid.UUID
-		MessageTopic    Topic
+		MessageID    uuid.UUID
+		MessageTopic Topic
+		// CorrelationID is shared by all the messages produced within the same chain,
+		// the messages produced by the handler inherit it
+		CorrelationID string
+		// CausationID is the ID of the message which handler produced the handled message, blank for the chain start
+		CausationID     string
 		MessageMetadata Metadata
 		Panic           *PanicErr
+		// ReceivedAt is the time the listener pulled the message from the Consumer
+		ReceivedAt time.Time
 	}
 
 	PanicErr struct {
@@ -33,13 +52,27 @@ func withHandlerMetadata(ctx context.Context, msg *Message, data Metadata) conte
 		data = make(Metadata)
 	}
 
+	correlationID := data[correlationIDMetaKey]
+	if correlationID == "" {
+		correlationID = msg.ID.String()
+	}
+
+	receivedAt, _ := ctx.Value(receivedAtContextKey).(time.Time)
 	return context.WithValue(ctx, handlerMetaContextKey, &HandlerMetadata{
 		MessageID:       msg.ID,
 		MessageTopic:    msg.Topic,
+		CorrelationID:   correlationID,
+		CausationID:     data[causationIDMetaKey],
 		MessageMetadata: data,
+		Panic:           nil,
+		ReceivedAt:      receivedAt,
 	})
 }
 
+func withReceivedAt(ctx context.Context, receivedAt time.Time) context.Context {
+	return context.WithValue(ctx, receivedAtContextKey, receivedAt)
+}
+
 func GetHandlerMetadata(ctx context.Context) *HandlerMetadata {
 	meta, ok := ctx.Value(handlerMetaContextKey).(*HandlerMetadata)
 	if ok {
@@ -48,3 +81,48 @@ func GetHandlerMetadata(ctx context.Context) *HandlerMetadata {
 
 	return &HandlerMetadata{MessageMetadata: make(Metadata)}
 }
+
+// correlationMetadata builds the metadata of the produced message, the message produced outside the handler
+// starts the new chain with the correlation ID extracted from the context or with its own ID
+func correlationMetadata(ctx context.Context, msg StructuredMessage, extractors []func(context.Context) string) Metadata {
+	handlerMeta, ok := ctx.Value(handlerMetaContextKey).(*HandlerMetadata)
+	if ok {
+		return Metadata{
+			correlationIDMetaKey: handlerMeta.CorrelationID,
+			causationIDMetaKey:   handlerMeta.MessageID.String(),
+		}
+	}
+
+	for _, extract := range extractors {
+		if correlationID := extract(ctx); correlationID != "" {
+			return Metadata{correlationIDMetaKey: correlationID}
+		}
+	}
+
+	return Metadata{correlationIDMetaKey: msg.ID().String()}
+}
+
+// producedAt is the time the message becomes available for delivery, scheduled messages are produced at the scheduled time
+func producedAt(scheduledAt *time.Time) time.Time {
+	now := time.Now()
+	if scheduledAt != nil && scheduledAt.After(now) {
+		return scheduledAt.UTC()
+	}
+
+	return now.UTC()
+}
+
+// GetMessageProducedAt returns the produced-at timestamp recorded in the message metadata
+func GetMessageProducedAt(meta Metadata) (time.Time, bool) {
+	value, ok := meta[producedAtMetaKey]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	result, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return result, true
+}
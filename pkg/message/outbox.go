@@ -3,6 +3,7 @@ package message
 import (
 	"context"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -11,23 +12,37 @@ import (
 	"github.com/klwxsrx/go-service-template/pkg/metric"
 )
 
-const defaultOutboxBatchSize = 100
+const (
+	defaultOutboxBatchSize = 100
+
+	// OutboxSubscriber acknowledges the relayed messages in the Storage, so the topics could have other subscribers
+	OutboxSubscriber Subscriber = "outbox"
+)
 
 type (
 	Outbox interface {
 		Worker(context.Context) error
 		Process()
+		// ProcessTopic processes the topic if it is relayed by the outbox, blank topic processes all of them
+		ProcessTopic(Topic)
 	}
 
 	OutboxOption func(*OutboxImpl)
 
 	OutboxImpl struct {
-		BatchSize        int
-		Retry            backoff.BackOff
+		BatchSize int
+		Retry     backoff.BackOff
+		// Topics is the allow-list of the relayed topics, the outbox subscribes to them as OutboxSubscriber.
+		// Blank Topics relays all the stored messages and deletes them, so the outbox must be the only storage reader
+		Topics []Topic
+		// KeyOrdered relays the next message with the same Message.Key only after the previous one is produced
+		KeyOrdered       bool
 		OnInternalError  []func(context.Context, error)
-		OnFoundMessages  []func(context.Context, []Message, error)
+		OnFoundMessages  []func(context.Context, []StoredMessage, error)
 		OnSentMessage    []func(context.Context, *Message, error)
 		OnDeletedMessage []func(context.Context, *Message, error)
+		// OnExpired is called for the expired message deleted without sending, see ExpiresAtHeader
+		OnExpired []func(context.Context, *Message)
 
 		storage     Storage
 		producer    Producer
@@ -50,10 +65,13 @@ func NewOutbox(
 	o := &OutboxImpl{
 		BatchSize:        defaultOutboxBatchSize,
 		Retry:            defaultRetry,
+		Topics:           nil,
+		KeyOrdered:       false,
 		OnInternalError:  nil,
 		OnFoundMessages:  nil,
 		OnSentMessage:    nil,
 		OnDeletedMessage: nil,
+		OnExpired:        nil,
 
 		storage:     storage,
 		producer:    producer,
@@ -67,6 +85,16 @@ func NewOutbox(
 }
 
 func (o *OutboxImpl) Worker(ctx context.Context) error {
+	for _, topic := range o.Topics {
+		err := backoff.Retry(
+			func() error { return o.storage.Subscribe(ctx, topic, OutboxSubscriber) },
+			backoff.WithContext(o.Retry, ctx),
+		)
+		if err != nil {
+			return fmt.Errorf("subscribe outbox to storage topic %s: %w", topic, err)
+		}
+	}
+
 	o.Process()
 
 	for {
@@ -86,6 +114,12 @@ func (o *OutboxImpl) Process() {
 	}
 }
 
+func (o *OutboxImpl) ProcessTopic(topic Topic) {
+	if topic == "" || len(o.Topics) == 0 || slices.Contains(o.Topics, topic) {
+		o.Process()
+	}
+}
+
 func (o *OutboxImpl) process(ctx context.Context) {
 	impl := func() error {
 		var err error
@@ -104,7 +138,12 @@ func (o *OutboxImpl) process(ctx context.Context) {
 }
 
 func (o *OutboxImpl) processBatch(ctx context.Context) (allProcessed bool, err error) {
-	ctx, releaseLock, err := o.storage.Lock(ctx)
+	var lockKeys []string
+	if len(o.Topics) > 0 {
+		lockKeys = []string{"subscriber", string(OutboxSubscriber)}
+	}
+
+	ctx, releaseLock, err := o.storage.Lock(ctx, lockKeys...)
 	if err != nil {
 		err = fmt.Errorf("get storage lock: %w", err)
 		for _, fn := range o.OnInternalError {
@@ -121,10 +160,18 @@ func (o *OutboxImpl) processBatch(ctx context.Context) (allProcessed bool, err e
 		}
 	}()
 
-	msgs, err := o.storage.Find(ctx, &StorageSpecification{
-		ScheduledAtBefore: time.Now(),
+	now := time.Now()
+	spec := &StorageSpecification{
+		ScheduledAtBefore: now,
+		Topics:            o.Topics,
+		KeyOrdered:        o.KeyOrdered,
 		Limit:             o.BatchSize,
-	})
+	}
+	if len(o.Topics) > 0 {
+		spec.Subscriber = OutboxSubscriber
+	}
+
+	msgs, err := o.storage.Find(ctx, spec)
 	for _, fn := range o.OnFoundMessages {
 		fn(ctx, msgs, err)
 	}
@@ -136,18 +183,34 @@ func (o *OutboxImpl) processBatch(ctx context.Context) (allProcessed bool, err e
 		return true, nil
 	}
 
+	liveMsgs := make([]StoredMessage, 0, len(msgs))
 	for _, msg := range msgs {
-		err = o.producer.Produce(ctx, &msg)
-		for _, fn := range o.OnSentMessage {
-			fn(ctx, &msg, err)
+		if !IsMessageExpired(&msg.Message, now) {
+			liveMsgs = append(liveMsgs, msg)
 		}
+	}
+
+	if len(liveMsgs) > 0 {
+		err = o.produce(ctx, liveMsgs)
 		if err != nil {
 			return false, fmt.Errorf("send message: %w", err)
 		}
+	}
+
+	for _, msg := range msgs {
+		if IsMessageExpired(&msg.Message, now) {
+			for _, fn := range o.OnExpired {
+				fn(ctx, &msg.Message)
+			}
+		}
 
-		err = o.storage.Delete(ctx, msg.Topic, msg.ID)
+		if len(o.Topics) > 0 {
+			err = o.storage.Acknowledge(ctx, OutboxSubscriber, msg.Topic, msg.ID)
+		} else {
+			err = o.storage.Delete(ctx, msg.Topic, msg.ID)
+		}
 		for _, fn := range o.OnDeletedMessage {
-			fn(ctx, &msg, err)
+			fn(ctx, &msg.Message, err)
 		}
 		if err != nil {
 			return false, fmt.Errorf("delete sent message: %w", err)
@@ -157,6 +220,55 @@ func (o *OutboxImpl) processBatch(ctx context.Context) (allProcessed bool, err e
 	return len(msgs) < o.BatchSize, nil
 }
 
+func (o *OutboxImpl) produce(ctx context.Context, storedMsgs []StoredMessage) error {
+	batchProducer, ok := o.producer.(BatchProducer)
+	if !ok {
+		for _, msg := range storedMsgs {
+			err := o.producer.Produce(ctx, &msg.Message)
+			for _, fn := range o.OnSentMessage {
+				fn(ctx, &msg.Message, err)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	msgs := make([]*Message, 0, len(storedMsgs))
+	for i := range storedMsgs {
+		msgs = append(msgs, &storedMsgs[i].Message)
+	}
+
+	err := batchProducer.ProduceBatch(ctx, msgs)
+	for _, msg := range msgs {
+		for _, fn := range o.OnSentMessage {
+			fn(ctx, msg, err)
+		}
+	}
+
+	return err
+}
+
+func WithOutboxTopics(topics ...Topic) OutboxOption {
+	return func(o *OutboxImpl) {
+		o.Topics = append(o.Topics, topics...)
+	}
+}
+
+func WithOutboxKeyOrdering() OutboxOption {
+	return func(o *OutboxImpl) {
+		o.KeyOrdered = true
+	}
+}
+
+func WithOutboxBatchSize(batchSize int) OutboxOption {
+	return func(o *OutboxImpl) {
+		o.BatchSize = batchSize
+	}
+}
+
 func WithOutboxRetry(retry backoff.BackOff) OutboxOption {
 	return func(o *OutboxImpl) {
 		o.Retry = retry
@@ -173,7 +285,7 @@ func WithOutboxLogging(
 			logger.WithError(err).Log(ctx, errorLevel, "message outbox internal error")
 		})
 
-		o.OnFoundMessages = append(o.OnFoundMessages, func(ctx context.Context, _ []Message, err error) {
+		o.OnFoundMessages = append(o.OnFoundMessages, func(ctx context.Context, _ []StoredMessage, err error) {
 			if err != nil {
 				logger.WithError(err).Log(ctx, errorLevel, "message outbox internal error")
 			}
@@ -188,6 +300,10 @@ func WithOutboxLogging(
 			}
 		})
 
+		o.OnExpired = append(o.OnExpired, func(ctx context.Context, msg *Message) {
+			logger.WithField("messageID", msg.ID).Log(ctx, infoLevel, "outbox message expired and dropped without sending")
+		})
+
 		o.OnDeletedMessage = append(o.OnDeletedMessage, func(ctx context.Context, msg *Message, err error) {
 			logger := logger.WithField("messageID", msg.ID)
 			if err != nil {
@@ -206,7 +322,7 @@ func WithOutboxMetrics(metrics metric.Metrics) OutboxOption {
 			metrics.Increment("msg_outbox_internal_error_total")
 		})
 
-		o.OnFoundMessages = append(o.OnFoundMessages, func(_ context.Context, _ []Message, err error) {
+		o.OnFoundMessages = append(o.OnFoundMessages, func(_ context.Context, _ []StoredMessage, err error) {
 			if err != nil {
 				metrics.Increment("msg_outbox_internal_error_total")
 			}
@@ -219,6 +335,10 @@ func WithOutboxMetrics(metrics metric.Metrics) OutboxOption {
 			}).Increment("msg_outbox_producer_sending_attempts_total")
 		})
 
+		o.OnExpired = append(o.OnExpired, func(_ context.Context, msg *Message) {
+			metrics.WithLabel("topic", msg.Topic).Increment("msg_outbox_expired_messages_total")
+		})
+
 		o.OnDeletedMessage = append(o.OnDeletedMessage, func(_ context.Context, msg *Message, err error) {
 			metrics.With(metric.Labels{
 				"success": err == nil,
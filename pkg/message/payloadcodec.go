@@ -0,0 +1,321 @@
+package message
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	PayloadCompressionGzip PayloadCompression = "gzip"
+	PayloadCompressionZstd PayloadCompression = "zstd"
+
+	PayloadEncryptionAESGCM = "aes-gcm"
+
+	payloadCodecMetaCompression = "codec/compression"
+	payloadCodecMetaEncryption  = "codec/encryption"
+	payloadCodecMetaKeyID       = "codec/key-id"
+
+	defaultPayloadCompressionThreshold = 1024
+	defaultPayloadMaxDecodedSize       = 64 << 20
+)
+
+// payloadCodecMagic starts the encoded payload, serialized messages never start with zero byte
+var payloadCodecMagic = []byte{0x00, 'g', 's', 'c'}
+
+var (
+	ErrPayloadCodecUnknownKey   = errors.New("unknown payload encryption key")
+	ErrPayloadCodecNotEncrypted = errors.New("payload isn't encrypted")
+	ErrPayloadCodecTooLarge     = errors.New("decoded payload is too large")
+)
+
+type (
+	PayloadCompression string
+
+	// PayloadKeyring contains AES keys by their IDs, payloads are encrypted with the current key
+	// and decrypted with the key recorded in the payload metadata, so the keys could be rotated
+	PayloadKeyring struct {
+		CurrentKeyID string
+		Keys         map[string][]byte
+	}
+
+	// PayloadCodec compresses and encrypts the serialized message payload,
+	// the codec and the key ID are recorded in the metadata header of the encoded payload
+	PayloadCodec struct {
+		Compression          PayloadCompression
+		CompressionThreshold int
+		Keyring              *PayloadKeyring
+		// RequireEncryption rejects the plain payloads on Decode, so the unencrypted messages aren't accepted
+		RequireEncryption bool
+		// MaxDecodedSize limits the decompressed payload, so the compressed payload can't exhaust the memory
+		MaxDecodedSize int
+
+		ciphers     map[string]cipher.AEAD
+		zstdEncoder *zstd.Encoder
+		zstdDecoder *zstd.Decoder
+	}
+
+	PayloadCodecOption func(*PayloadCodec)
+
+	// PayloadEncoder transforms the serialized message before it is produced
+	PayloadEncoder func([]byte) ([]byte, error)
+	// PayloadDecoder reverses PayloadEncoder before the message is deserialized
+	PayloadDecoder func([]byte) ([]byte, error)
+)
+
+func NewPayloadCodec(opts ...PayloadCodecOption) (*PayloadCodec, error) {
+	codec := &PayloadCodec{
+		Compression:          "",
+		CompressionThreshold: defaultPayloadCompressionThreshold,
+		Keyring:              nil,
+		RequireEncryption:    false,
+		MaxDecodedSize:       defaultPayloadMaxDecodedSize,
+		ciphers:              make(map[string]cipher.AEAD),
+		zstdEncoder:          nil,
+		zstdDecoder:          nil,
+	}
+	for _, opt := range opts {
+		opt(codec)
+	}
+
+	switch codec.Compression {
+	case "", PayloadCompressionGzip, PayloadCompressionZstd:
+	default:
+		return nil, fmt.Errorf("unsupported payload compression %s", codec.Compression)
+	}
+
+	if codec.MaxDecodedSize <= 0 {
+		return nil, fmt.Errorf("invalid payload max decoded size %d", codec.MaxDecodedSize)
+	}
+
+	var err error
+	if codec.Compression == PayloadCompressionZstd {
+		codec.zstdEncoder, err = zstd.NewWriter(nil)
+		if err != nil {
+			return nil, fmt.Errorf("create zstd encoder: %w", err)
+		}
+	}
+	codec.zstdDecoder, err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(codec.MaxDecodedSize))) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("create zstd decoder: %w", err)
+	}
+
+	if codec.Keyring == nil {
+		if codec.RequireEncryption {
+			return nil, errors.New("payload encryption is required, but the keyring isn't set")
+		}
+		return codec, nil
+	}
+
+	if _, ok := codec.Keyring.Keys[codec.Keyring.CurrentKeyID]; !ok {
+		return nil, fmt.Errorf("%w %s", ErrPayloadCodecUnknownKey, codec.Keyring.CurrentKeyID)
+	}
+	for keyID, key := range codec.Keyring.Keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("create cipher for key %s: %w", keyID, err)
+		}
+
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("create gcm for key %s: %w", keyID, err)
+		}
+
+		codec.ciphers[keyID] = gcm
+	}
+
+	return codec, nil
+}
+
+func (c *PayloadCodec) Encode(payload []byte) ([]byte, error) {
+	meta := make(Metadata)
+	data := payload
+
+	if c.Compression != "" && len(payload) >= c.CompressionThreshold {
+		compressed, err := c.compress(payload)
+		if err != nil {
+			return nil, fmt.Errorf("compress payload with %s: %w", c.Compression, err)
+		}
+
+		meta[payloadCodecMetaCompression] = string(c.Compression)
+		data = compressed
+	}
+
+	var gcm cipher.AEAD
+	if c.Keyring != nil {
+		meta[payloadCodecMetaEncryption] = PayloadEncryptionAESGCM
+		meta[payloadCodecMetaKeyID] = c.Keyring.CurrentKeyID
+		gcm = c.ciphers[c.Keyring.CurrentKeyID]
+	}
+
+	if len(meta) == 0 {
+		return payload, nil
+	}
+
+	header, err := json.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("encode payload codec metadata: %w", err)
+	}
+
+	if gcm != nil {
+		nonce := make([]byte, gcm.NonceSize())
+		_, err = rand.Read(nonce)
+		if err != nil {
+			return nil, fmt.Errorf("generate nonce: %w", err)
+		}
+
+		data = gcm.Seal(nonce, nonce, data, header)
+	}
+
+	result := make([]byte, 0, len(payloadCodecMagic)+binary.MaxVarintLen64+len(header)+len(data))
+	result = append(result, payloadCodecMagic...)
+	result = binary.AppendUvarint(result, uint64(len(header)))
+	result = append(result, header...)
+	return append(result, data...), nil
+}
+
+// Decode returns the payload as is if it wasn't encoded, so the codec could be enabled for the existing topics.
+// The plain payloads are rejected with ErrPayloadCodecNotEncrypted if the RequireEncryption is set
+func (c *PayloadCodec) Decode(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, payloadCodecMagic) {
+		if c.RequireEncryption {
+			return nil, ErrPayloadCodecNotEncrypted
+		}
+		return data, nil
+	}
+	data = data[len(payloadCodecMagic):]
+
+	headerLen, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < headerLen {
+		return nil, errors.New("invalid payload codec header")
+	}
+	header := data[n : n+int(headerLen)]
+	payload := data[n+int(headerLen):]
+
+	var meta Metadata
+	err := json.Unmarshal(header, &meta)
+	if err != nil {
+		return nil, fmt.Errorf("decode payload codec metadata: %w", err)
+	}
+
+	encryption, ok := meta[payloadCodecMetaEncryption]
+	if !ok && c.RequireEncryption {
+		return nil, ErrPayloadCodecNotEncrypted
+	}
+	if ok {
+		if encryption != PayloadEncryptionAESGCM {
+			return nil, fmt.Errorf("unsupported payload encryption %s", encryption)
+		}
+
+		keyID := meta[payloadCodecMetaKeyID]
+		gcm, ok := c.ciphers[keyID]
+		if !ok {
+			return nil, fmt.Errorf("%w %s", ErrPayloadCodecUnknownKey, keyID)
+		}
+		if len(payload) < gcm.NonceSize() {
+			return nil, errors.New("encrypted payload is too short")
+		}
+
+		payload, err = gcm.Open(nil, payload[:gcm.NonceSize()], payload[gcm.NonceSize():], header)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt payload with key %s: %w", keyID, err)
+		}
+	}
+
+	if compression, ok := meta[payloadCodecMetaCompression]; ok {
+		payload, err = c.decompress(PayloadCompression(compression), payload)
+		if err != nil {
+			return nil, fmt.Errorf("decompress payload with %s: %w", compression, err)
+		}
+	}
+
+	return payload, nil
+}
+
+func WithPayloadCompression(compression PayloadCompression, threshold int) PayloadCodecOption {
+	return func(c *PayloadCodec) {
+		c.Compression = compression
+		c.CompressionThreshold = threshold
+	}
+}
+
+func WithPayloadEncryption(keyring PayloadKeyring) PayloadCodecOption {
+	return func(c *PayloadCodec) {
+		c.Keyring = &keyring
+	}
+}
+
+// WithPayloadEncryptionRequired sets PayloadCodec.RequireEncryption, use it after all the producers encrypt payloads
+func WithPayloadEncryptionRequired() PayloadCodecOption {
+	return func(c *PayloadCodec) {
+		c.RequireEncryption = true
+	}
+}
+
+func WithPayloadMaxDecodedSize(size int) PayloadCodecOption {
+	return func(c *PayloadCodec) {
+		c.MaxDecodedSize = size
+	}
+}
+
+func (c *PayloadCodec) compress(payload []byte) ([]byte, error) {
+	switch c.Compression {
+	case PayloadCompressionGzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		_, err := writer.Write(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		err = writer.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	case PayloadCompressionZstd:
+		return c.zstdEncoder.EncodeAll(payload, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported payload compression %s", c.Compression)
+	}
+}
+
+func (c *PayloadCodec) decompress(compression PayloadCompression, payload []byte) ([]byte, error) {
+	switch compression {
+	case PayloadCompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		decoded, err := io.ReadAll(io.LimitReader(reader, int64(c.MaxDecodedSize)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(decoded) > c.MaxDecodedSize {
+			return nil, fmt.Errorf("%w, max size is %d", ErrPayloadCodecTooLarge, c.MaxDecodedSize)
+		}
+
+		return decoded, nil
+	case PayloadCompressionZstd:
+		decoded, err := c.zstdDecoder.DecodeAll(payload, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, fmt.Errorf("%w, max size is %d", ErrPayloadCodecTooLarge, c.MaxDecodedSize)
+		}
+
+		return decoded, err
+	default:
+		return nil, fmt.Errorf("unsupported payload compression %s", compression)
+	}
+}
@@ -0,0 +1,49 @@
+package message
+
+import (
+	"context"
+	"strconv"
+)
+
+// PriorityHeader carries the message Priority, the storage consumers get the higher priority messages first
+const PriorityHeader = "message-priority"
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// Priority is any integer, the higher value means the more urgent message
+type Priority int
+
+// WithProducePriority overrides the priority of the messages produced within the context, see WithBusProducerPriority
+func WithProducePriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, producePriorityContextKey, priority)
+}
+
+// WithBusProducerPriority sets the priority of the registered messages
+func WithBusProducerPriority(priority Priority) BusProducerOption {
+	return func(config *BusProducerConfig) {
+		config.Priority = priority
+	}
+}
+
+// GetMessagePriority returns PriorityNormal for the messages without PriorityHeader
+func GetMessagePriority(msg *Message) Priority {
+	priority, err := strconv.Atoi(msg.Headers[PriorityHeader])
+	if err != nil {
+		return PriorityNormal
+	}
+
+	return Priority(priority)
+}
+
+func getProducePriority(ctx context.Context, defaultPriority Priority) Priority {
+	priority, ok := ctx.Value(producePriorityContextKey).(Priority)
+	if !ok {
+		return defaultPriority
+	}
+
+	return priority
+}
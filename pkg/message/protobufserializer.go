@@ -0,0 +1,284 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	protobufMessageTypeField    protowire.Number = 1
+	protobufMessageVersionField protowire.Number = 2
+	protobufMessageMetaField    protowire.Number = 3
+	protobufMessagePayloadField protowire.Number = 4
+
+	protobufMetaKeyField   protowire.Number = 1
+	protobufMetaValueField protowire.Number = 2
+)
+
+var errProtobufMessageRequired = errors.New("message must implement proto.Message")
+
+type (
+	// ProtobufSerializer encodes messages implementing proto.Message to the binary envelope:
+	//
+	//	message Envelope {
+	//	  string type = 1;
+	//	  int64 version = 2;
+	//	  map<string, string> meta = 3;
+	//	  bytes payload = 4;
+	//	}
+	//
+	// Protobuf schemas evolve by field numbers, so the payloads of the older versions are decoded
+	// to the current message struct and upcasters are not supported
+	ProtobufSerializer struct {
+		schemaRegistry *SchemaRegistry
+		serializers    map[string]int
+		deserializers  map[string]protobufDeserializer
+	}
+
+	ProtobufSerializerOption func(*ProtobufSerializer)
+
+	protobufDeserializer struct {
+		Version int
+		New     func() proto.Message
+	}
+
+	protobufMessage struct {
+		Type    string
+		Version int
+		Meta    Metadata
+		Payload []byte
+	}
+)
+
+func NewProtobufSerializer(opts ...ProtobufSerializerOption) *ProtobufSerializer {
+	s := &ProtobufSerializer{
+		schemaRegistry: nil,
+		serializers:    make(map[string]int),
+		deserializers:  make(map[string]protobufDeserializer),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *ProtobufSerializer) Serialize(msg StructuredMessage, meta Metadata) ([]byte, error) {
+	protoMsg, ok := msg.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("encode message %v %s: %w", msg.ID(), msg.Type(), errProtobufMessageRequired)
+	}
+
+	payload, err := proto.Marshal(protoMsg)
+	if err != nil {
+		return nil, fmt.Errorf("encode message %v %s: %w", msg.ID(), msg.Type(), err)
+	}
+
+	return protobufMessage{
+		Type:    msg.Type(),
+		Version: GetMessageVersion(msg),
+		Meta:    meta,
+		Payload: payload,
+	}.Marshal(), nil
+}
+
+func (s *ProtobufSerializer) Deserialize(data []byte) (StructuredMessage, Metadata, error) {
+	var msgData protobufMessage
+	err := msgData.Unmarshal(data)
+	if err != nil {
+		return nil, nil, ErrDeserializeUnknownMessage
+	}
+
+	deserializer, ok := s.deserializers[msgData.Type]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %s", ErrDeserializeUnknownMessage, msgData.Type)
+	}
+	if msgData.Version > deserializer.Version {
+		return nil, nil, fmt.Errorf(
+			"%w %d for %s, current version is %d",
+			ErrDeserializeNewerVersion,
+			msgData.Version,
+			msgData.Type,
+			deserializer.Version,
+		)
+	}
+
+	protoMsg := deserializer.New()
+	err = proto.Unmarshal(msgData.Payload, protoMsg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("deserialize message: protobuf decode %T: %w", protoMsg, err)
+	}
+
+	msg, ok := protoMsg.(StructuredMessage)
+	if !ok {
+		return nil, nil, fmt.Errorf("deserialize message: %T is not a structured message", protoMsg)
+	}
+
+	return msg, msgData.Meta, nil
+}
+
+func (s *ProtobufSerializer) RegisterSerializer(schema StructuredMessage) error {
+	msgType, version := schema.Type(), GetMessageVersion(schema)
+	if _, ok := schema.(proto.Message); !ok {
+		return fmt.Errorf("serializer for %v: %w", msgType, errProtobufMessageRequired)
+	}
+	if registered, ok := s.serializers[msgType]; ok && registered != version {
+		return fmt.Errorf("serializer for %v already exists with version %d", msgType, registered)
+	}
+
+	if s.schemaRegistry != nil {
+		err := s.schemaRegistry.Register(msgType, version)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.serializers[msgType] = version
+	return nil
+}
+
+// RegisterDeserializer ignores the passed payload deserializer and decodes the payload to the new instance of the schema
+func (s *ProtobufSerializer) RegisterDeserializer(
+	schema StructuredMessage,
+	_ PayloadDeserializer,
+	upcasters map[int]PayloadDeserializer,
+) error {
+	msgType, version := schema.Type(), GetMessageVersion(schema)
+	if _, ok := s.deserializers[msgType]; ok {
+		return fmt.Errorf("deserializer for %v already exists", msgType)
+	}
+	if len(upcasters) > 0 {
+		return fmt.Errorf("deserializer for %v: upcasters are not supported for protobuf messages", msgType)
+	}
+
+	protoSchema, ok := schema.(proto.Message)
+	if !ok {
+		return fmt.Errorf("deserializer for %v: %w", msgType, errProtobufMessageRequired)
+	}
+
+	if s.schemaRegistry != nil {
+		err := s.schemaRegistry.Register(msgType, version)
+		if err != nil {
+			return err
+		}
+	}
+
+	msgReflectType := protoSchema.ProtoReflect().Type()
+	s.deserializers[msgType] = protobufDeserializer{
+		Version: version,
+		New: func() proto.Message {
+			return msgReflectType.New().Interface()
+		},
+	}
+	return nil
+}
+
+func WithProtobufSerializerSchemaRegistry(registry *SchemaRegistry) ProtobufSerializerOption {
+	return func(s *ProtobufSerializer) {
+		s.schemaRegistry = registry
+	}
+}
+
+func (m protobufMessage) Marshal() []byte {
+	data := protowire.AppendTag(nil, protobufMessageTypeField, protowire.BytesType)
+	data = protowire.AppendString(data, m.Type)
+
+	if m.Version != 0 {
+		data = protowire.AppendTag(data, protobufMessageVersionField, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(m.Version))
+	}
+
+	metaKeys := make([]string, 0, len(m.Meta))
+	for key := range m.Meta {
+		metaKeys = append(metaKeys, key)
+	}
+	sort.Strings(metaKeys)
+	for _, key := range metaKeys {
+		entry := protowire.AppendTag(nil, protobufMetaKeyField, protowire.BytesType)
+		entry = protowire.AppendString(entry, key)
+		entry = protowire.AppendTag(entry, protobufMetaValueField, protowire.BytesType)
+		entry = protowire.AppendString(entry, m.Meta[key])
+
+		data = protowire.AppendTag(data, protobufMessageMetaField, protowire.BytesType)
+		data = protowire.AppendBytes(data, entry)
+	}
+
+	data = protowire.AppendTag(data, protobufMessagePayloadField, protowire.BytesType)
+	return protowire.AppendBytes(data, m.Payload)
+}
+
+func (m *protobufMessage) Unmarshal(data []byte) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch {
+		case num == protobufMessageTypeField && typ == protowire.BytesType:
+			m.Type, n = protowire.ConsumeString(data)
+		case num == protobufMessageVersionField && typ == protowire.VarintType:
+			var version uint64
+			version, n = protowire.ConsumeVarint(data)
+			m.Version = int(version)
+		case num == protobufMessageMetaField && typ == protowire.BytesType:
+			var entry []byte
+			entry, n = protowire.ConsumeBytes(data)
+			if n >= 0 {
+				err := m.unmarshalMetaEntry(entry)
+				if err != nil {
+					return err
+				}
+			}
+		case num == protobufMessagePayloadField && typ == protowire.BytesType:
+			m.Payload, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+
+	if m.Type == "" {
+		return errors.New("message type is missing")
+	}
+
+	return nil
+}
+
+func (m *protobufMessage) unmarshalMetaEntry(data []byte) error {
+	var key, value string
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch {
+		case num == protobufMetaKeyField && typ == protowire.BytesType:
+			key, n = protowire.ConsumeString(data)
+		case num == protobufMetaValueField && typ == protowire.BytesType:
+			value, n = protowire.ConsumeString(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+
+	if m.Meta == nil {
+		m.Meta = make(Metadata)
+	}
+	m.Meta[key] = value
+	return nil
+}
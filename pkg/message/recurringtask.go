@@ -0,0 +1,300 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/klwxsrx/go-service-template/pkg/log"
+	"github.com/klwxsrx/go-service-template/pkg/metric"
+	"github.com/klwxsrx/go-service-template/pkg/persistence"
+	"github.com/klwxsrx/go-service-template/pkg/task"
+)
+
+const (
+	// CatchUpSkip schedules the occurrence only if it is late no more than RecurringTaskSchedulerImpl.MaxDelay
+	CatchUpSkip CatchUpPolicy = iota
+	// CatchUpRunOnce schedules the latest missed occurrence only
+	CatchUpRunOnce
+	// CatchUpRunAll schedules all the missed occurrences
+	CatchUpRunAll
+)
+
+const (
+	defaultRecurringTaskMaxDelay      = time.Minute
+	defaultRecurringTaskCheckInterval = time.Minute
+	maxRecurringTaskCatchUpRuns       = 1000
+	recurringTaskLockPrefix           = "recurring_task_"
+)
+
+type (
+	// RecurrenceSchedule is identified by its String definition, the stored next run is recalculated when it changes
+	RecurrenceSchedule interface {
+		Next(time.Time) time.Time
+		String() string
+	}
+
+	CatchUpPolicy int
+
+	// RecurringTask is scheduled into the TaskScheduler on every occurrence,
+	// the task type must be registered with RegisterTask as well
+	RecurringTask struct {
+		Name     string
+		Schedule RecurrenceSchedule
+		CatchUp  CatchUpPolicy
+		NewTask  func(occurrence time.Time) task.Task
+	}
+
+	// RecurringTaskStorage persists the next occurrence of the recurring task,
+	// FindNextRun returns nil if the next run wasn't saved for the specified schedule
+	RecurringTaskStorage interface {
+		FindNextRun(ctx context.Context, name, schedule string) (*time.Time, error)
+		SaveNextRun(ctx context.Context, name, schedule string, nextRun time.Time) error
+	}
+
+	RecurringTaskRegistry interface {
+		RegisterRecurring(...RecurringTask) error
+	}
+
+	RecurringTaskScheduler interface {
+		RecurringTaskRegistry
+		Worker(context.Context) error
+	}
+
+	RecurringTaskSchedulerOption func(*RecurringTaskSchedulerImpl)
+
+	// RecurringTaskSchedulerImpl schedules occurrences within the transaction locked by the task name,
+	// so every occurrence is scheduled exactly once by one of the running instances
+	RecurringTaskSchedulerImpl struct {
+		MaxDelay      time.Duration
+		CheckInterval time.Duration
+		OnScheduled   []func(_ context.Context, name string, occurrences []time.Time, _ error)
+
+		scheduler   task.Scheduler
+		storage     RecurringTaskStorage
+		transaction persistence.Transaction
+		mutex       *sync.Mutex
+		tasks       map[string]RecurringTask
+	}
+
+	cronSchedule struct {
+		cron.Schedule
+		expr string
+	}
+
+	intervalSchedule struct {
+		interval time.Duration
+	}
+)
+
+func NewCronSchedule(expr string) (RecurrenceSchedule, error) {
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parse cron expression %s: %w", expr, err)
+	}
+
+	return cronSchedule{Schedule: schedule, expr: expr}, nil
+}
+
+func NewIntervalSchedule(interval time.Duration) (RecurrenceSchedule, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid schedule interval %v, must be positive", interval)
+	}
+
+	return intervalSchedule{interval: interval}, nil
+}
+
+func NewRecurringTask[T task.Task](
+	name string,
+	schedule RecurrenceSchedule,
+	catchUp CatchUpPolicy,
+	newTask func(occurrence time.Time) T,
+) RecurringTask {
+	return RecurringTask{
+		Name:     name,
+		Schedule: schedule,
+		CatchUp:  catchUp,
+		NewTask: func(occurrence time.Time) task.Task {
+			return newTask(occurrence)
+		},
+	}
+}
+
+func NewRecurringTaskScheduler(
+	scheduler task.Scheduler,
+	storage RecurringTaskStorage,
+	transaction persistence.Transaction,
+	opts ...RecurringTaskSchedulerOption,
+) RecurringTaskScheduler {
+	s := &RecurringTaskSchedulerImpl{
+		MaxDelay:      defaultRecurringTaskMaxDelay,
+		CheckInterval: defaultRecurringTaskCheckInterval,
+		OnScheduled:   nil,
+
+		scheduler:   scheduler,
+		storage:     storage,
+		transaction: transaction,
+		mutex:       &sync.Mutex{},
+		tasks:       make(map[string]RecurringTask),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *RecurringTaskSchedulerImpl) RegisterRecurring(tasks ...RecurringTask) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, recurringTask := range tasks {
+		if recurringTask.Name == "" {
+			return fmt.Errorf("blank recurring task name")
+		}
+		if _, ok := s.tasks[recurringTask.Name]; ok {
+			return fmt.Errorf("recurring task %s already registered", recurringTask.Name)
+		}
+
+		s.tasks[recurringTask.Name] = recurringTask
+	}
+
+	return nil
+}
+
+func (s *RecurringTaskSchedulerImpl) Worker(ctx context.Context) error {
+	for {
+		nextRun := time.Now().Add(s.CheckInterval)
+
+		s.mutex.Lock()
+		tasks := make([]RecurringTask, 0, len(s.tasks))
+		for _, recurringTask := range s.tasks {
+			tasks = append(tasks, recurringTask)
+		}
+		s.mutex.Unlock()
+
+		for _, recurringTask := range tasks {
+			taskNextRun, err := s.scheduleOccurrences(ctx, recurringTask, time.Now())
+			if err == nil && taskNextRun.Before(nextRun) {
+				nextRun = taskNextRun
+			}
+		}
+
+		select {
+		case <-time.After(max(time.Until(nextRun), 0)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *RecurringTaskSchedulerImpl) scheduleOccurrences(
+	ctx context.Context,
+	recurringTask RecurringTask,
+	now time.Time,
+) (nextRun time.Time, err error) {
+	var occurrences []time.Time
+	err = s.transaction.WithinContext(ctx, func(ctx context.Context) error {
+		schedule := recurringTask.Schedule.String()
+		storedNextRun, err := s.storage.FindNextRun(ctx, recurringTask.Name, schedule)
+		if err != nil {
+			return fmt.Errorf("find next run: %w", err)
+		}
+		if storedNextRun == nil {
+			nextRun = recurringTask.Schedule.Next(now)
+			return s.storage.SaveNextRun(ctx, recurringTask.Name, schedule, nextRun)
+		}
+
+		nextRun = *storedNextRun
+		var due []time.Time
+		for !nextRun.After(now) && len(due) < maxRecurringTaskCatchUpRuns {
+			due = append(due, nextRun)
+			nextRun = recurringTask.Schedule.Next(nextRun)
+		}
+		if len(due) == 0 {
+			return nil
+		}
+
+		occurrences = s.selectOccurrences(recurringTask.CatchUp, due, now)
+		for _, occurrence := range occurrences {
+			err = s.scheduler.Schedule(ctx, occurrence, recurringTask.NewTask(occurrence))
+			if err != nil {
+				return fmt.Errorf("schedule occurrence at %v: %w", occurrence, err)
+			}
+		}
+
+		return s.storage.SaveNextRun(ctx, recurringTask.Name, schedule, nextRun)
+	}, persistence.Lock{Key: recurringTaskLockPrefix + recurringTask.Name, Shared: false})
+	if err != nil || len(occurrences) > 0 {
+		for _, fn := range s.OnScheduled {
+			fn(ctx, recurringTask.Name, occurrences, err)
+		}
+	}
+
+	return nextRun, err
+}
+
+func (s *RecurringTaskSchedulerImpl) selectOccurrences(policy CatchUpPolicy, due []time.Time, now time.Time) []time.Time {
+	latest := due[len(due)-1]
+	switch policy {
+	case CatchUpRunAll:
+		return due
+	case CatchUpRunOnce:
+		return []time.Time{latest}
+	case CatchUpSkip:
+		if now.Sub(latest) <= s.MaxDelay {
+			return []time.Time{latest}
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (s cronSchedule) String() string {
+	return s.expr
+}
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+func (s intervalSchedule) String() string {
+	return "@every " + s.interval.String()
+}
+
+func WithRecurringTaskMaxDelay(maxDelay time.Duration) RecurringTaskSchedulerOption {
+	return func(s *RecurringTaskSchedulerImpl) {
+		s.MaxDelay = maxDelay
+	}
+}
+
+func WithRecurringTaskLogging(logger log.Logger, infoLevel, errorLevel log.Level) RecurringTaskSchedulerOption {
+	return func(s *RecurringTaskSchedulerImpl) {
+		s.OnScheduled = append(s.OnScheduled, func(ctx context.Context, name string, occurrences []time.Time, err error) {
+			loggerWithFields := logger.With(log.Fields{
+				"recurringTask": name,
+				"occurrences":   occurrences,
+			})
+			if err != nil {
+				loggerWithFields.WithError(err).Log(ctx, errorLevel, "recurring task scheduling failed")
+			} else {
+				loggerWithFields.Log(ctx, infoLevel, "recurring task occurrences scheduled")
+			}
+		})
+	}
+}
+
+func WithRecurringTaskMetrics(metrics metric.Metrics) RecurringTaskSchedulerOption {
+	return func(s *RecurringTaskSchedulerImpl) {
+		s.OnScheduled = append(s.OnScheduled, func(_ context.Context, name string, occurrences []time.Time, err error) {
+			metrics.With(metric.Labels{
+				"name":    name,
+				"success": err == nil,
+			}).Count("msg_recurring_task_scheduled_occurrences_total", len(occurrences))
+		})
+	}
+}
@@ -4,37 +4,95 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 )
 
-var ErrDeserializeUnknownMessage = errors.New("unknown message")
+var (
+	ErrDeserializeUnknownMessage     = errors.New("unknown message")
+	ErrDeserializeUnsupportedVersion = errors.New("unsupported message version")
+	// ErrDeserializeNewerVersion is the ErrDeserializeUnsupportedVersion of the message produced by the newer schema,
+	// the message is redelivered until the consumer is updated
+	ErrDeserializeNewerVersion        = fmt.Errorf("newer %w", ErrDeserializeUnsupportedVersion)
+	ErrSchemaRegistryVersionsMismatch = errors.New("message versions mismatch")
+)
 
 type (
 	Serializer interface {
 		Serialize(StructuredMessage, Metadata) ([]byte, error)
+		RegisterSerializer(schema StructuredMessage) error
 	}
 
 	Deserializer interface {
 		Deserialize([]byte) (StructuredMessage, Metadata, error)
-		RegisterDeserializer(msgType string, _ PayloadDeserializer) error
+		// RegisterDeserializer registers the deserializer of the current message version
+		// and the upcasters of the older versions to the current message struct
+		RegisterDeserializer(
+			schema StructuredMessage,
+			_ PayloadDeserializer,
+			upcasters map[int]PayloadDeserializer,
+		) error
+	}
+
+	// SchemaRegistry checks that producers and consumers registered within the process agree on the latest message version
+	SchemaRegistry struct {
+		mutex    *sync.Mutex
+		versions map[string]int
 	}
 
+	JSONSerializerOption func(*JSONSerializer)
+
 	JSONSerializer struct {
-		deserializers map[string]PayloadDeserializer
+		schemaRegistry *SchemaRegistry
+		serializers    map[string]int
+		deserializers  map[string]versionedDeserializers
 	}
 
 	PayloadDeserializer func([]byte) (StructuredMessage, error)
 
+	versionedDeserializers struct {
+		Current  int
+		Versions map[int]PayloadDeserializer
+	}
+
 	jsonMessage struct {
 		Type    string   `json:"type"`
+		Version int      `json:"version,omitempty"`
 		Payload string   `json:"payload"`
 		Meta    Metadata `json:"meta,omitempty"`
 	}
 )
 
-func NewJSONSerializer() *JSONSerializer {
-	return &JSONSerializer{
-		deserializers: make(map[string]PayloadDeserializer),
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{
+		mutex:    &sync.Mutex{},
+		versions: make(map[string]int),
+	}
+}
+
+func (r *SchemaRegistry) Register(msgType string, version int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	registered, ok := r.versions[msgType]
+	if ok && registered != version {
+		return fmt.Errorf("%w for %s: %d and %d", ErrSchemaRegistryVersionsMismatch, msgType, registered, version)
+	}
+
+	r.versions[msgType] = version
+	return nil
+}
+
+func NewJSONSerializer(opts ...JSONSerializerOption) *JSONSerializer {
+	s := &JSONSerializer{
+		schemaRegistry: nil,
+		serializers:    make(map[string]int),
+		deserializers:  make(map[string]versionedDeserializers),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
 func (s *JSONSerializer) Serialize(msg StructuredMessage, meta Metadata) ([]byte, error) {
@@ -45,6 +103,7 @@ func (s *JSONSerializer) Serialize(msg StructuredMessage, meta Metadata) ([]byte
 
 	msgData, err := json.Marshal(jsonMessage{
 		Type:    msg.Type(),
+		Version: GetMessageVersion(msg),
 		Payload: string(payload),
 		Meta:    meta,
 	})
@@ -62,11 +121,16 @@ func (s *JSONSerializer) Deserialize(data []byte) (StructuredMessage, Metadata,
 		return nil, nil, ErrDeserializeUnknownMessage
 	}
 
-	deserializer, ok := s.deserializers[msgData.Type]
+	deserializers, ok := s.deserializers[msgData.Type]
 	if !ok {
 		return nil, nil, fmt.Errorf("%w %s", ErrDeserializeUnknownMessage, msgData.Type)
 	}
 
+	deserializer, err := deserializers.Get(msgData.Type, msgData.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	msg, err := deserializer([]byte(msgData.Payload))
 	if err != nil {
 		return nil, nil, fmt.Errorf("deserialize message: %w", err)
@@ -75,15 +139,55 @@ func (s *JSONSerializer) Deserialize(data []byte) (StructuredMessage, Metadata,
 	return msg, msgData.Meta, nil
 }
 
-func (s *JSONSerializer) RegisterDeserializer(msgType string, deserializer PayloadDeserializer) error {
+func (s *JSONSerializer) RegisterSerializer(schema StructuredMessage) error {
+	msgType, version := schema.Type(), GetMessageVersion(schema)
+	if registered, ok := s.serializers[msgType]; ok && registered != version {
+		return fmt.Errorf("serializer for %v already exists with version %d", msgType, registered)
+	}
+
+	if s.schemaRegistry != nil {
+		err := s.schemaRegistry.Register(msgType, version)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.serializers[msgType] = version
+	return nil
+}
+
+func (s *JSONSerializer) RegisterDeserializer(
+	schema StructuredMessage,
+	deserializer PayloadDeserializer,
+	upcasters map[int]PayloadDeserializer,
+) error {
+	msgType, version := schema.Type(), GetMessageVersion(schema)
 	if _, ok := s.deserializers[msgType]; ok {
 		return fmt.Errorf("deserializer for %v already exists", msgType)
 	}
 
-	s.deserializers[msgType] = deserializer
+	deserializers, err := newVersionedDeserializers(version, deserializer, upcasters)
+	if err != nil {
+		return fmt.Errorf("deserializer for %v: %w", msgType, err)
+	}
+
+	if s.schemaRegistry != nil {
+		err = s.schemaRegistry.Register(msgType, version)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.deserializers[msgType] = deserializers
 	return nil
 }
 
+func WithJSONSerializerSchemaRegistry(registry *SchemaRegistry) JSONSerializerOption {
+	return func(s *JSONSerializer) {
+		s.schemaRegistry = registry
+	}
+}
+
 func PayloadDeserializerImpl[T StructuredMessage](payload []byte) (StructuredMessage, error) {
 	var msg T
 	err := json.Unmarshal(payload, &msg)
@@ -93,3 +197,45 @@ func PayloadDeserializerImpl[T StructuredMessage](payload []byte) (StructuredMes
 
 	return msg, nil
 }
+
+func newVersionedDeserializers(
+	version int,
+	deserializer PayloadDeserializer,
+	upcasters map[int]PayloadDeserializer,
+) (versionedDeserializers, error) {
+	versions := make(map[int]PayloadDeserializer, len(upcasters)+1)
+	for upcasterVersion, upcaster := range upcasters {
+		if upcasterVersion < 1 || upcasterVersion >= version {
+			return versionedDeserializers{}, fmt.Errorf(
+				"upcaster version %d must be between 1 and the current version %d",
+				upcasterVersion,
+				version,
+			)
+		}
+
+		versions[upcasterVersion] = upcaster
+	}
+	versions[version] = deserializer
+
+	return versionedDeserializers{
+		Current:  version,
+		Versions: versions,
+	}, nil
+}
+
+func (d versionedDeserializers) Get(msgType string, version int) (PayloadDeserializer, error) {
+	if version < 1 {
+		version = 1
+	}
+
+	if version > d.Current {
+		return nil, fmt.Errorf("%w %d for %s, current version is %d", ErrDeserializeNewerVersion, version, msgType, d.Current)
+	}
+
+	deserializer, ok := d.Versions[version]
+	if !ok {
+		return nil, fmt.Errorf("%w %d for %s, current version is %d", ErrDeserializeUnsupportedVersion, version, msgType, d.Current)
+	}
+
+	return deserializer, nil
+}
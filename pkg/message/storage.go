@@ -2,23 +2,90 @@ package message
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+var ErrStorageMessageNotFound = errors.New("stored message not found")
+
 type (
 	StorageSpecification struct {
+		// Subscriber selects messages not acknowledged by the subscriber with its own delivery state,
+		// blank Subscriber selects all the stored messages
+		Subscriber        Subscriber
+		IDs               []uuid.UUID
 		IDsExcluded       []uuid.UUID
 		Topics            []Topic
 		ScheduledAtBefore time.Time
-		Limit             int
+		// KeyOrdered selects only the earliest stored message not acknowledged yet for every non-blank Message.Key
+		KeyOrdered bool
+		// FailedOnly selects messages having failed delivery attempts only, see StoredMessage
+		FailedOnly bool
+		Limit      int
+	}
+
+	// StorageTopicStats describes the topic backlog of the subscriber, pending messages are the ones which delivery
+	// time has come. Blank Subscriber describes the messages of the topic without subscribers
+	StorageTopicStats struct {
+		Topic                    Topic
+		Subscriber               Subscriber
+		Pending                  int
+		OldestPendingScheduledAt *time.Time
+		Scheduled                int
+	}
+
+	// StoredMessage is the Message with its delivery state, ScheduledAt is the time of the next delivery attempt.
+	// The failure state is of the StorageSpecification.Subscriber, without it the state is of the subscriber having
+	// the most failed attempts
+	StoredMessage struct {
+		Message
+		ScheduledAt   time.Time
+		Attempts      int
+		LastError     *string
+		FirstFailedAt *time.Time
+	}
+
+	// StorageDeliveryFailure is the failed delivery state recorded by Storage.RescheduleDelivery,
+	// the recorded attempts never decrease
+	StorageDeliveryFailure struct {
+		Err           error
+		Attempts      int
+		FirstFailedAt time.Time
 	}
 
 	Storage interface {
 		Lock(ctx context.Context, extraKeys ...string) (_ context.Context, release func() error, _ error)
-		Find(ctx context.Context, spec *StorageSpecification) ([]Message, error)
+		Find(ctx context.Context, spec *StorageSpecification) ([]StoredMessage, error)
+		// Stats returns the backlog of the topic subscribers having stored messages not acknowledged yet
+		Stats(ctx context.Context, now time.Time) ([]StorageTopicStats, error)
+		// Topics returns the topics having stored messages or subscribers
+		Topics(ctx context.Context) ([]Topic, error)
 		Store(ctx context.Context, scheduledAt time.Time, msgs ...Message) error
+		// StoreDeduplicated replaces the stored messages of the message topic having the same deduplication key,
+		// the replaced message could be already in-flight, so both messages could be delivered
+		StoreDeduplicated(ctx context.Context, scheduledAt time.Time, deduplicationKey string, msg Message) error
 		Delete(ctx context.Context, topic Topic, ids ...uuid.UUID) error
+		// CancelByID deletes the message from the topics, no topics means all of them.
+		// Returns ErrStorageMessageNotFound if nothing was deleted
+		CancelByID(ctx context.Context, id uuid.UUID, topics ...Topic) error
+		// RescheduleByID moves the message in the topics to the specified time for all the subscribers
+		// that haven't acknowledged it yet, no topics means all of them. The ExpiresAtHeader is moved by the same
+		// interval. Returns ErrStorageMessageNotFound if nothing was moved
+		RescheduleByID(ctx context.Context, id uuid.UUID, scheduledAt time.Time, topics ...Topic) error
+
+		// Subscribe registers the subscriber, messages are deleted only when all the topic subscribers acknowledged them
+		Subscribe(ctx context.Context, topic Topic, subscriber Subscriber) error
+		Acknowledge(ctx context.Context, subscriber Subscriber, topic Topic, ids ...uuid.UUID) error
+		// RescheduleDelivery moves the message delivery for the subscriber only, non-nil failure is recorded
+		RescheduleDelivery(
+			ctx context.Context,
+			subscriber Subscriber,
+			topic Topic,
+			id uuid.UUID,
+			scheduledAt time.Time,
+			failure *StorageDeliveryFailure,
+		) error
 	}
 )
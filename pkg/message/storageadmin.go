@@ -0,0 +1,239 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/klwxsrx/go-service-template/pkg/persistence"
+)
+
+var ErrStorageAdminMessageExists = errors.New("message already exists in the target topic")
+
+// storageAdminScheduledAtHorizon selects the messages scheduled in the future as well
+const storageAdminScheduledAtHorizon = 100 * 365 * 24 * time.Hour
+
+type (
+	StorageAdminFilter struct {
+		Topics []Topic
+		// Subscriber shows the delivery state of the subscriber, blank Subscriber shows the state of the message itself
+		Subscriber Subscriber
+		FailedOnly bool
+		// OlderThan selects messages which delivery is scheduled earlier than the specified duration ago,
+		// zero value selects the messages scheduled in the future as well
+		OlderThan time.Duration
+		Limit     int
+	}
+
+	// StorageAdminMessage is the stored message with the decoded payload,
+	// DecodeError is set if the message type isn't registered within the StorageAdmin
+	StorageAdminMessage struct {
+		StoredMessage
+		Decoded     StructuredMessage
+		Metadata    Metadata
+		DecodeError error
+	}
+
+	// StorageAdmin inspects and repairs the stored messages, it implements HandlerRegistry
+	// to collect the deserializers of the handled messages without running the handlers
+	StorageAdmin struct {
+		storage      Storage
+		transaction  persistence.Transaction
+		deserializer func() Deserializer
+		mutex        *sync.Mutex
+		topics       map[Topic]*storageAdminTopic
+	}
+
+	storageAdminTopic struct {
+		Deserializer    Deserializer
+		PayloadDecoders []PayloadDecoder
+		MessageTypes    map[string]struct{}
+	}
+)
+
+func NewStorageAdmin(storage Storage, transaction persistence.Transaction, deserializer func() Deserializer) *StorageAdmin {
+	return &StorageAdmin{
+		storage:      storage,
+		transaction:  transaction,
+		deserializer: deserializer,
+		mutex:        &sync.Mutex{},
+		topics:       make(map[Topic]*storageAdminTopic),
+	}
+}
+
+func (a *StorageAdmin) RegisterHandlers(_ Subscriber, handlers TopicHandlers, opts ...ListenerOption) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	listenerConfig := &ListenerImpl{}
+	for _, opt := range opts {
+		opt(listenerConfig)
+	}
+
+	for topic, funcs := range handlers {
+		topicData, ok := a.topics[topic]
+		if !ok {
+			topicData = &storageAdminTopic{
+				Deserializer:    a.deserializer(),
+				PayloadDecoders: listenerConfig.PayloadDecoders,
+				MessageTypes:    make(map[string]struct{}),
+			}
+			a.topics[topic] = topicData
+		}
+
+		for _, fn := range funcs {
+			msgHandlers := fn()
+			msgType := msgHandlers.Schema.Type()
+			if _, ok := topicData.MessageTypes[msgType]; ok {
+				continue
+			}
+
+			err := topicData.Deserializer.RegisterDeserializer(msgHandlers.Schema, msgHandlers.Deserializer, msgHandlers.Upcasters)
+			if err != nil {
+				return fmt.Errorf("register deserializer for %T in topic %s: %w", msgHandlers.Schema, topic, err)
+			}
+			topicData.MessageTypes[msgType] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+func (a *StorageAdmin) List(ctx context.Context, filter StorageAdminFilter) ([]StorageAdminMessage, error) {
+	scheduledAtBefore := time.Now().Add(storageAdminScheduledAtHorizon)
+	if filter.OlderThan > 0 {
+		scheduledAtBefore = time.Now().Add(-filter.OlderThan)
+	}
+
+	msgs, err := a.storage.Find(ctx, &StorageSpecification{
+		Subscriber:        filter.Subscriber,
+		Topics:            filter.Topics,
+		ScheduledAtBefore: scheduledAtBefore,
+		FailedOnly:        filter.FailedOnly,
+		Limit:             filter.Limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find messages: %w", err)
+	}
+
+	result := make([]StorageAdminMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		decoded, meta, err := a.decode(msg.Message)
+		result = append(result, StorageAdminMessage{
+			StoredMessage: msg,
+			Decoded:       decoded,
+			Metadata:      meta,
+			DecodeError:   err,
+		})
+	}
+
+	return result, nil
+}
+
+// Requeue schedules the immediate delivery of the messages for all the subscribers,
+// blank topic requeues the messages in all the topics
+func (a *StorageAdmin) Requeue(ctx context.Context, topic Topic, ids ...uuid.UUID) error {
+	var topics []Topic
+	if topic != "" {
+		topics = []Topic{topic}
+	}
+
+	for _, id := range ids {
+		err := a.storage.RescheduleByID(ctx, id, time.Now(), topics...)
+		if err != nil {
+			return fmt.Errorf("requeue message %v: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// Move stores the messages to the target topic and deletes them from the source one,
+// the delivery state is reset and the message is delivered to the target topic subscribers immediately.
+// Returns ErrStorageAdminMessageExists if any of them is already stored in the target topic
+func (a *StorageAdmin) Move(ctx context.Context, from, to Topic, ids ...uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return a.transaction.WithinContext(ctx, func(ctx context.Context) error {
+		msgs, err := a.storage.Find(ctx, &StorageSpecification{
+			IDs:               ids,
+			Topics:            []Topic{from},
+			ScheduledAtBefore: time.Now().Add(storageAdminScheduledAtHorizon),
+		})
+		if err != nil {
+			return fmt.Errorf("find messages: %w", err)
+		}
+		if len(msgs) == 0 {
+			return ErrStorageMessageNotFound
+		}
+
+		moved := make([]Message, 0, len(msgs))
+		movedIDs := make([]uuid.UUID, 0, len(msgs))
+		for _, msg := range msgs {
+			movedMsg := msg.Message
+			movedMsg.Topic = to
+			moved = append(moved, movedMsg)
+			movedIDs = append(movedIDs, msg.ID)
+		}
+
+		// the storage skips the messages already stored in the topic, so the source ones would be lost
+		existing, err := a.storage.Find(ctx, &StorageSpecification{
+			IDs:               movedIDs,
+			Topics:            []Topic{to},
+			ScheduledAtBefore: time.Now().Add(storageAdminScheduledAtHorizon),
+		})
+		if err != nil {
+			return fmt.Errorf("find messages in %s: %w", to, err)
+		}
+		if len(existing) > 0 {
+			return fmt.Errorf("%w: message %v in %s", ErrStorageAdminMessageExists, existing[0].ID, to)
+		}
+
+		err = a.storage.Store(ctx, time.Now(), moved...)
+		if err != nil {
+			return fmt.Errorf("store messages to %s: %w", to, err)
+		}
+
+		err = a.storage.Delete(ctx, from, movedIDs...)
+		if err != nil {
+			return fmt.Errorf("delete messages from %s: %w", from, err)
+		}
+
+		return nil
+	})
+}
+
+func (a *StorageAdmin) Purge(ctx context.Context, topic Topic, ids ...uuid.UUID) error {
+	err := a.storage.Delete(ctx, topic, ids...)
+	if err != nil {
+		return fmt.Errorf("delete messages from %s: %w", topic, err)
+	}
+
+	return nil
+}
+
+func (a *StorageAdmin) decode(msg Message) (StructuredMessage, Metadata, error) {
+	a.mutex.Lock()
+	topicData, ok := a.topics[msg.Topic]
+	a.mutex.Unlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: no handlers registered for topic %s", ErrDeserializeUnknownMessage, msg.Topic)
+	}
+
+	payload := msg.Payload
+	var err error
+	for i := len(topicData.PayloadDecoders) - 1; i >= 0; i-- {
+		payload, err = topicData.PayloadDecoders[i](payload)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decode payload: %w", err)
+		}
+	}
+
+	return topicData.Deserializer.Deserialize(payload)
+}
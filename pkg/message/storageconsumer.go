@@ -17,42 +17,65 @@ import (
 	"github.com/klwxsrx/go-service-template/pkg/worker"
 )
 
-const defaultStorageConsumerBatchSize = 100
+const (
+	defaultStorageConsumerBatchSize       = 100
+	defaultStorageConsumerNackInterval    = time.Second
+	defaultStorageConsumerNackMaxInterval = time.Hour
+)
 
 type (
 	StorageConsumerProvider interface {
-		ConsumerProvider[AckStrategy]
+		ConsumerProvider[AckNackStrategy]
+		TopicEnumerator
 		Process()
+		// ProcessTopic processes the topic consumer only, blank topic processes all of them
+		ProcessTopic(Topic)
+		// Workers returns the workers of the consumers created so far, the later ones run their workers themselves,
+		// see ConsumerWorker
 		Workers() []worker.ContextJob
 	}
 
 	StorageConsumerProviderOption func(*StorageConsumerProviderImpl)
 
+	// StorageNackDelay returns the delay before the next delivery attempt of the message failed the specified number of times
+	StorageNackDelay func(attempts int) time.Duration
+
 	StorageConsumerProviderImpl struct {
 		ConsumingBatchSize      int
 		ConsumingRetry          backoff.BackOff
+		KeyOrdered              bool
+		NackDelay               StorageNackDelay
 		OnMessageProcessing     []func(context.Context, Topic, *Message)
 		OnAcknowledge           []func(context.Context, Topic, *Message, error)
+		OnNegativeAcknowledge   []func(_ context.Context, _ Topic, _ *Message, attempts int, _ error)
 		OnMessageBatchProcessed []func(context.Context, Topic, int, error)
+		// OnExpired is called when the expired message is acknowledged without delivering, see ExpiresAtHeader
+		OnExpired []func(_ context.Context, _ Topic, _ *Message, ackErr error)
 
 		storage   Storage
-		consumers map[Topic]*storageConsumer
+		mutex     *sync.RWMutex
+		consumers map[subscriberKey]*storageConsumer
 	}
 
 	storageConsumer struct {
 		topic                   Topic
+		subscriber              Subscriber
 		consumingBatchSize      int
+		keyOrdered              bool
 		storage                 Storage
 		messagesCh              chan *ConsumerMessage
 		allProcessed            *sync.Cond
 		processedCount          int
 		mutex                   *sync.RWMutex
-		processingMessages      map[uuid.UUID]struct{}
+		processingMessages      map[uuid.UUID]int
 		processChan             chan struct{}
 		retry                   backoff.BackOff
+		nackDelay               StorageNackDelay
 		onMessageProcessing     []func(context.Context, Topic, *Message)
 		onAcknowledge           []func(context.Context, Topic, *Message, error)
+		onNegativeAcknowledge   []func(context.Context, Topic, *Message, int, error)
 		onMessageBatchProcessed []func(context.Context, Topic, int, error)
+		onExpired               []func(context.Context, Topic, *Message, error)
 	}
 )
 
@@ -67,12 +90,17 @@ func NewStorageConsumerProvider(storage Storage, opts ...StorageConsumerProvider
 	provider := &StorageConsumerProviderImpl{
 		ConsumingBatchSize:      defaultStorageConsumerBatchSize,
 		ConsumingRetry:          defaultRetry,
+		KeyOrdered:              false,
+		NackDelay:               ExponentialStorageNackDelay(defaultStorageConsumerNackInterval, defaultStorageConsumerNackMaxInterval),
 		OnMessageProcessing:     nil,
 		OnAcknowledge:           nil,
+		OnNegativeAcknowledge:   nil,
 		OnMessageBatchProcessed: nil,
+		OnExpired:               nil,
 
 		storage:   storage,
-		consumers: make(map[Topic]*storageConsumer),
+		mutex:     &sync.RWMutex{},
+		consumers: make(map[subscriberKey]*storageConsumer),
 	}
 
 	for _, opt := range opts {
@@ -82,33 +110,73 @@ func NewStorageConsumerProvider(storage Storage, opts ...StorageConsumerProvider
 	return provider
 }
 
-func (p *StorageConsumerProviderImpl) Consumer(topic Topic, _ Subscriber) (Consumer[AckStrategy], error) {
-	_, ok := p.consumers[topic]
+func (p *StorageConsumerProviderImpl) Consumer(topic Topic, subscriber Subscriber) (Consumer[AckNackStrategy], error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key := subscriberKey{Subscriber: subscriber, Topic: topic}
+	_, ok := p.consumers[key]
 	if ok {
-		return nil, fmt.Errorf("consumer for topic %s already exists, only one is supported at a time", topic)
+		return nil, fmt.Errorf("consumer for topic %s by %s already exists, only one is supported at a time", topic, subscriber)
 	}
 
 	consumer := newStorageConsumer(
 		topic,
+		subscriber,
 		p.ConsumingBatchSize,
+		p.KeyOrdered,
 		p.storage,
 		p.ConsumingRetry,
+		p.NackDelay,
 		p.OnMessageProcessing,
 		p.OnAcknowledge,
+		p.OnNegativeAcknowledge,
 		p.OnMessageBatchProcessed,
+		p.OnExpired,
 	)
-	p.consumers[topic] = consumer
+	p.consumers[key] = consumer
 
 	return consumer, nil
 }
 
+func (p *StorageConsumerProviderImpl) Topics(ctx context.Context) ([]Topic, error) {
+	topics, err := p.storage.Topics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get storage topics: %w", err)
+	}
+
+	return topics, nil
+}
+
 func (p *StorageConsumerProviderImpl) Process() {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
 	for _, consumer := range p.consumers {
 		consumer.Process()
 	}
 }
 
+func (p *StorageConsumerProviderImpl) ProcessTopic(topic Topic) {
+	if topic == "" {
+		p.Process()
+		return
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for key, consumer := range p.consumers {
+		if key.Topic == topic {
+			consumer.Process()
+		}
+	}
+}
+
 func (p *StorageConsumerProviderImpl) Workers() []worker.ContextJob {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
 	workers := make([]worker.ContextJob, 0, len(p.consumers))
 	for _, consumer := range p.consumers {
 		workers = append(workers, consumer.Worker)
@@ -119,27 +187,37 @@ func (p *StorageConsumerProviderImpl) Workers() []worker.ContextJob {
 
 func newStorageConsumer(
 	topic Topic,
+	subscriber Subscriber,
 	consumingBatchSize int,
+	keyOrdered bool,
 	storage Storage,
 	retry backoff.BackOff,
+	nackDelay StorageNackDelay,
 	onMessageProcessing []func(context.Context, Topic, *Message),
 	onAcknowledge []func(context.Context, Topic, *Message, error),
+	onNegativeAcknowledge []func(context.Context, Topic, *Message, int, error),
 	onMessageBatchProcessed []func(context.Context, Topic, int, error),
+	onExpired []func(context.Context, Topic, *Message, error),
 ) *storageConsumer {
 	return &storageConsumer{
 		topic:                   topic,
+		subscriber:              subscriber,
 		consumingBatchSize:      consumingBatchSize,
+		keyOrdered:              keyOrdered,
 		storage:                 storage,
 		messagesCh:              make(chan *ConsumerMessage),
 		allProcessed:            sync.NewCond(&sync.Mutex{}),
 		processedCount:          0,
 		mutex:                   &sync.RWMutex{},
-		processingMessages:      make(map[uuid.UUID]struct{}),
+		processingMessages:      make(map[uuid.UUID]int),
 		processChan:             make(chan struct{}, 1),
 		retry:                   retry,
+		nackDelay:               nackDelay,
 		onMessageProcessing:     onMessageProcessing,
 		onAcknowledge:           onAcknowledge,
+		onNegativeAcknowledge:   onNegativeAcknowledge,
 		onMessageBatchProcessed: onMessageBatchProcessed,
+		onExpired:               onExpired,
 	}
 }
 
@@ -151,6 +229,15 @@ func (c *storageConsumer) Process() {
 }
 
 func (c *storageConsumer) Worker(ctx context.Context) error {
+	err := backoff.Retry(
+		func() error { return c.storage.Subscribe(ctx, c.topic, c.subscriber) },
+		backoff.WithContext(c.retry, ctx),
+	)
+	if err != nil {
+		close(c.messagesCh)
+		return fmt.Errorf("subscribe %s to storage topic %s: %w", c.subscriber, c.topic, err)
+	}
+
 	c.Process()
 
 	for {
@@ -169,7 +256,7 @@ func (c *storageConsumer) Topic() Topic {
 }
 
 func (c *storageConsumer) Subscriber() Subscriber {
-	return "message-storage-consumer"
+	return c.subscriber
 }
 
 func (c *storageConsumer) Messages() <-chan *ConsumerMessage {
@@ -177,19 +264,55 @@ func (c *storageConsumer) Messages() <-chan *ConsumerMessage {
 }
 
 func (c *storageConsumer) Ack(ctx context.Context, msg *ConsumerMessage) error {
-	err := c.storage.Delete(ctx, msg.Message.Topic, msg.Message.ID)
+	err := c.storage.Acknowledge(ctx, c.subscriber, msg.Message.Topic, msg.Message.ID)
 	for _, fn := range c.onAcknowledge {
 		fn(ctx, c.topic, &msg.Message, err)
 	}
 	if err != nil {
-		return fmt.Errorf("delete message from storage: %w", err)
+		return fmt.Errorf("acknowledge message in storage: %w", err)
+	}
+
+	c.removeFromProcessing(msg.Message.ID)
+	return nil
+}
+
+func (c *storageConsumer) Nack(ctx context.Context, msg *ConsumerMessage, reason error) error {
+	if reason == nil {
+		reason = errors.New("message negatively acknowledged")
+	}
+
+	// the listener counts all the failed handler attempts, see ConsumerMessage.Attempts
+	now := time.Now()
+	failure := &StorageDeliveryFailure{
+		Err:           reason,
+		Attempts:      max(c.getProcessingAttempts(msg.Message.ID)+1, msg.Attempts),
+		FirstFailedAt: now,
+	}
+	if msg.FirstFailedAt != nil {
+		failure.FirstFailedAt = *msg.FirstFailedAt
+	}
+
+	attempts := failure.Attempts
+	err := c.storage.RescheduleDelivery(
+		ctx,
+		c.subscriber,
+		msg.Message.Topic,
+		msg.Message.ID,
+		now.Add(c.nackDelay(attempts)),
+		failure,
+	)
+	for _, fn := range c.onNegativeAcknowledge {
+		fn(ctx, c.topic, &msg.Message, attempts, err)
+	}
+	if err != nil {
+		return fmt.Errorf("reschedule message in storage: %w", err)
 	}
 
 	c.removeFromProcessing(msg.Message.ID)
 	return nil
 }
 
-func (c *storageConsumer) Acknowledge() AckStrategy {
+func (c *storageConsumer) Acknowledge() AckNackStrategy {
 	return c
 }
 
@@ -219,7 +342,7 @@ func (c *storageConsumer) consumeStorageMessages(ctx context.Context) {
 }
 
 func (c *storageConsumer) consumeStorageMessagesBatch(ctx context.Context) (allProcessed bool, processedCount int, err error) {
-	ctx, releaseLock, err := c.storage.Lock(ctx, "topic", string(c.topic))
+	ctx, releaseLock, err := c.storage.Lock(ctx, "topic", string(c.topic), "subscriber", string(c.subscriber))
 	if err != nil {
 		return false, 0, fmt.Errorf("get topic lock: %w", err)
 	}
@@ -230,10 +353,13 @@ func (c *storageConsumer) consumeStorageMessagesBatch(ctx context.Context) (allP
 		}
 	}()
 
+	now := time.Now()
 	msgs, err := c.storage.Find(ctx, &StorageSpecification{
+		Subscriber:        c.subscriber,
 		IDsExcluded:       c.getProcessingMessageIDs(),
 		Topics:            []Topic{c.topic},
-		ScheduledAtBefore: time.Now(),
+		ScheduledAtBefore: now,
+		KeyOrdered:        c.keyOrdered,
 		Limit:             c.consumingBatchSize,
 	})
 	if err != nil {
@@ -244,14 +370,31 @@ func (c *storageConsumer) consumeStorageMessagesBatch(ctx context.Context) (allP
 	}
 
 	for _, msg := range msgs {
+		if IsMessageExpired(&msg.Message, now) {
+			err = c.storage.Acknowledge(ctx, c.subscriber, msg.Topic, msg.ID)
+			for _, fn := range c.onExpired {
+				fn(ctx, c.topic, &msg.Message, err)
+			}
+			if err != nil {
+				return false, processedCount, fmt.Errorf("acknowledge expired message: %w", err)
+			}
+			continue
+		}
+
+		c.addToProcessing(msg.ID, msg.Attempts)
 		select {
-		case c.messagesCh <- &ConsumerMessage{Context: ctx, Message: msg}:
-			c.addToProcessing(msg.ID)
+		case c.messagesCh <- &ConsumerMessage{
+			Context:       ctx,
+			Message:       msg.Message,
+			Attempts:      msg.Attempts,
+			FirstFailedAt: msg.FirstFailedAt,
+		}:
 			for _, fn := range c.onMessageProcessing {
-				fn(ctx, c.topic, &msg)
+				fn(ctx, c.topic, &msg.Message)
 			}
 			processedCount++
 		case <-ctx.Done():
+			c.removeFromProcessing(msg.ID)
 			return true, processedCount, ctx.Err()
 		}
 	}
@@ -265,14 +408,21 @@ func (c *storageConsumer) consumeStorageMessagesBatch(ctx context.Context) (allP
 	return len(msgs) < c.consumingBatchSize, processedCount, nil
 }
 
-func (c *storageConsumer) addToProcessing(id uuid.UUID) {
+func (c *storageConsumer) addToProcessing(id uuid.UUID, attempts int) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	c.processingMessages[id] = struct{}{}
+	c.processingMessages[id] = attempts
 	c.processedCount = len(c.processingMessages)
 }
 
+func (c *storageConsumer) getProcessingAttempts(id uuid.UUID) int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.processingMessages[id]
+}
+
 func (c *storageConsumer) removeFromProcessing(id uuid.UUID) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -308,11 +458,24 @@ func WithStorageConsumerLogging(logger log.Logger, infoLevel, errorLevel log.Lev
 				return
 			}
 			if err != nil {
-				logger.WithError(err).Log(ctx, errorLevel, "failed to delete acknowledged message")
+				logger.WithError(err).Log(ctx, errorLevel, "failed to acknowledge message in storage")
 				return
 			}
 
-			logger.Log(ctx, infoLevel, "acknowledged message deleted from storage")
+			logger.Log(ctx, infoLevel, "message acknowledged in storage")
+		})
+
+		impl.OnNegativeAcknowledge = append(impl.OnNegativeAcknowledge, func(ctx context.Context, topic Topic, msg *Message, attempts int, err error) {
+			logger := logger.With(log.Fields{"topic": topic, "messageID": msg.ID, "attempts": attempts})
+			if err != nil && errors.Is(err, ctx.Err()) {
+				return
+			}
+			if err != nil {
+				logger.WithError(err).Log(ctx, errorLevel, "failed to reschedule negatively acknowledged message")
+				return
+			}
+
+			logger.Log(ctx, infoLevel, "negatively acknowledged message rescheduled in storage")
 		})
 
 		impl.OnMessageBatchProcessed = append(impl.OnMessageBatchProcessed, func(ctx context.Context, _ Topic, _ int, err error) {
@@ -325,6 +488,19 @@ func WithStorageConsumerLogging(logger log.Logger, infoLevel, errorLevel log.Lev
 
 			logger.WithError(err).Log(ctx, errorLevel, "failed to process storage messages to consume")
 		})
+
+		impl.OnExpired = append(impl.OnExpired, func(ctx context.Context, topic Topic, msg *Message, err error) {
+			logger := logger.With(log.Fields{"topic": topic, "messageID": msg.ID})
+			if err != nil && errors.Is(err, ctx.Err()) {
+				return
+			}
+			if err != nil {
+				logger.WithError(err).Log(ctx, errorLevel, "failed to acknowledge expired message in storage")
+				return
+			}
+
+			logger.Log(ctx, infoLevel, "expired message dropped from storage")
+		})
 	}
 }
 
@@ -344,6 +520,16 @@ func WithStorageConsumerMetrics(metrics metric.Metrics) StorageConsumerProviderO
 			metrics.Increment("msg_storage_consumer_delete_acked_total")
 		})
 
+		impl.OnNegativeAcknowledge = append(impl.OnNegativeAcknowledge, func(_ context.Context, topic Topic, _ *Message, _ int, err error) {
+			metrics := metrics.WithLabel("topic", topic)
+			if err != nil {
+				metrics.Increment("msg_storage_consumer_reschedule_nacked_error_total")
+				return
+			}
+
+			metrics.Increment("msg_storage_consumer_reschedule_nacked_total")
+		})
+
 		impl.OnMessageBatchProcessed = append(impl.OnMessageBatchProcessed, func(_ context.Context, topic Topic, _ int, err error) {
 			if err == nil {
 				return
@@ -351,6 +537,16 @@ func WithStorageConsumerMetrics(metrics metric.Metrics) StorageConsumerProviderO
 
 			metrics.WithLabel("topic", topic).Increment("msg_storage_consumer_internal_errors_total")
 		})
+
+		impl.OnExpired = append(impl.OnExpired, func(_ context.Context, topic Topic, _ *Message, err error) {
+			metrics := metrics.WithLabel("topic", topic)
+			if err != nil {
+				metrics.Increment("msg_storage_consumer_drop_expired_error_total")
+				return
+			}
+
+			metrics.Increment("msg_storage_consumer_drop_expired_total")
+		})
 	}
 }
 
@@ -360,8 +556,33 @@ func WithStorageConsumerBatchSize(size int) StorageConsumerProviderOption {
 	}
 }
 
+// WithStorageConsumerKeyOrdering delivers the next message with the same Message.Key only after the previous one is acknowledged
+func WithStorageConsumerKeyOrdering() StorageConsumerProviderOption {
+	return func(impl *StorageConsumerProviderImpl) {
+		impl.KeyOrdered = true
+	}
+}
+
 func WithStorageConsumerRetry(retry backoff.BackOff) StorageConsumerProviderOption {
 	return func(impl *StorageConsumerProviderImpl) {
 		impl.ConsumingRetry = retry
 	}
 }
+
+func WithStorageConsumerNackDelay(delay StorageNackDelay) StorageConsumerProviderOption {
+	return func(impl *StorageConsumerProviderImpl) {
+		impl.NackDelay = delay
+	}
+}
+
+// ExponentialStorageNackDelay doubles the delay with every failed attempt starting from the initial value
+func ExponentialStorageNackDelay(initial, maxDelay time.Duration) StorageNackDelay {
+	return func(attempts int) time.Duration {
+		delay := initial
+		for i := 1; i < attempts && delay < maxDelay; i++ {
+			delay *= 2
+		}
+
+		return min(delay, maxDelay)
+	}
+}
@@ -0,0 +1,74 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/klwxsrx/go-service-template/pkg/metric"
+)
+
+// StorageMetricsCollector reports the Storage backlog per topic subscriber, run Collect periodically
+type StorageMetricsCollector struct {
+	storage     Storage
+	metrics     metric.Metrics
+	mutex       *sync.Mutex
+	subscribers map[subscriberKey]struct{}
+}
+
+func NewStorageMetricsCollector(storage Storage, metrics metric.Metrics) *StorageMetricsCollector {
+	return &StorageMetricsCollector{
+		storage:     storage,
+		metrics:     metrics,
+		mutex:       &sync.Mutex{},
+		subscribers: make(map[subscriberKey]struct{}),
+	}
+}
+
+func (c *StorageMetricsCollector) Collect(ctx context.Context) error {
+	now := time.Now()
+	stats, err := c.storage.Stats(ctx, now)
+	if err != nil {
+		return fmt.Errorf("get storage stats: %w", err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// the subscribers without stored messages are reported as empty, otherwise the last gauge values stay
+	drained := c.subscribers
+	c.subscribers = make(map[subscriberKey]struct{}, len(stats))
+	for _, topicStats := range stats {
+		key := subscriberKey{Subscriber: topicStats.Subscriber, Topic: topicStats.Topic}
+		delete(drained, key)
+		c.subscribers[key] = struct{}{}
+		c.report(now, topicStats)
+	}
+	for key := range drained {
+		c.report(now, StorageTopicStats{
+			Topic:                    key.Topic,
+			Subscriber:               key.Subscriber,
+			Pending:                  0,
+			OldestPendingScheduledAt: nil,
+			Scheduled:                0,
+		})
+	}
+
+	return nil
+}
+
+func (c *StorageMetricsCollector) report(now time.Time, stats StorageTopicStats) {
+	var oldestPendingAge time.Duration
+	if stats.OldestPendingScheduledAt != nil {
+		oldestPendingAge = now.Sub(*stats.OldestPendingScheduledAt)
+	}
+
+	metrics := c.metrics.With(metric.Labels{
+		"topic":      stats.Topic,
+		"subscriber": stats.Subscriber,
+	})
+	metrics.Gauge("msg_storage_pending_messages", stats.Pending)
+	metrics.Gauge("msg_storage_oldest_pending_message_age_seconds", int(oldestPendingAge.Seconds()))
+	metrics.Gauge("msg_storage_scheduled_messages", stats.Scheduled)
+}
@@ -2,9 +2,13 @@ package message
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
+
 	"github.com/klwxsrx/go-service-template/pkg/task"
 )
 
@@ -15,15 +19,22 @@ type (
 	}
 
 	taskScheduler struct {
-		bus BusScheduledProducer
+		bus     BusScheduledProducer
+		storage Storage
+		mutex   *sync.RWMutex
+		topics  map[Topic]struct{}
 	}
 )
 
 func NewTaskScheduler(
 	bus BusScheduledProducer,
+	storage Storage,
 ) TaskScheduler {
 	return taskScheduler{
-		bus: bus,
+		bus:     bus,
+		storage: storage,
+		mutex:   &sync.RWMutex{},
+		topics:  make(map[Topic]struct{}),
 	}
 }
 
@@ -45,19 +56,85 @@ func (s taskScheduler) Schedule(ctx context.Context, at time.Time, tasks ...task
 	return nil
 }
 
+func (s taskScheduler) Cancel(ctx context.Context, id uuid.UUID) error {
+	topics := s.taskTopics()
+	if len(topics) == 0 {
+		return fmt.Errorf("%w: %v", task.ErrNotFound, id)
+	}
+
+	err := s.storage.CancelByID(ctx, id, topics...)
+	if errors.Is(err, ErrStorageMessageNotFound) {
+		return fmt.Errorf("%w: %v", task.ErrNotFound, id)
+	}
+	if err != nil {
+		return fmt.Errorf("cancel task %v: %w", id, err)
+	}
+
+	return nil
+}
+
+func (s taskScheduler) Reschedule(ctx context.Context, id uuid.UUID, at time.Time) error {
+	topics := s.taskTopics()
+	if len(topics) == 0 {
+		return fmt.Errorf("%w: %v", task.ErrNotFound, id)
+	}
+
+	err := s.storage.RescheduleByID(ctx, id, at, topics...)
+	if errors.Is(err, ErrStorageMessageNotFound) {
+		return fmt.Errorf("%w: %v", task.ErrNotFound, id)
+	}
+	if err != nil {
+		return fmt.Errorf("reschedule task %v: %w", id, err)
+	}
+
+	return nil
+}
+
 func (s taskScheduler) Register(messages TopicMessages, opts ...BusProducerOption) error {
-	return s.bus.Register(messages, opts...)
+	err := s.bus.Register(messages, opts...)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for topic := range messages {
+		s.topics[topic] = struct{}{}
+	}
+
+	return nil
+}
+
+// taskTopics returns the registered task queues, the dead-letter topics keep the copies of the tasks
+// with the same IDs, so they are never cancelled or rescheduled
+func (s taskScheduler) taskTopics() []Topic {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	topics := make([]Topic, 0, len(s.topics))
+	for topic := range s.topics {
+		if !isTopicDeadLetter(topic) {
+			topics = append(topics, topic)
+		}
+	}
+
+	return topics
 }
 
 func RegisterTask[T task.Task]() RegisterMessageFunc {
-	return func() (StructuredMessage, KeyBuilder) {
+	return func() RegisteredMessage {
 		var blank T
-		return blank, nil
+		return RegisteredMessage{
+			Schema:     blank,
+			KeyBuilder: nil,
+			TTL:        0,
+		}
 	}
 }
 
 func RegisterTaskHandlers[T task.Task](handlers ...task.TypedHandler[T]) RegisterHandlersFunc {
-	return func() (StructuredMessage, PayloadDeserializer, []TypedHandler[StructuredMessage]) {
+	return func() MessageHandlers {
 		handlersImpl := make([]TypedHandler[StructuredMessage], 0, len(handlers))
 		for _, handler := range handlers {
 			handlersImpl = append(handlersImpl, func(ctx context.Context, msg StructuredMessage) error {
@@ -71,7 +148,28 @@ func RegisterTaskHandlers[T task.Task](handlers ...task.TypedHandler[T]) Registe
 		}
 
 		var blank T
-		return blank, PayloadDeserializerImpl[T], handlersImpl
+		return MessageHandlers{
+			Schema:       blank,
+			Deserializer: PayloadDeserializerImpl[T],
+			Handlers:     handlersImpl,
+			Upcasters:    nil,
+			Timeout:      0,
+			BatchSize:    0,
+		}
+	}
+}
+
+// RegisterTaskBatchHandlers registers handlers receiving the tasks in batches, see BatchConfig and BatchItemErrors
+func RegisterTaskBatchHandlers[T task.Task](config BatchConfig, handlers ...task.TypedBatchHandler[T]) RegisterHandlersFunc {
+	return func() MessageHandlers {
+		typedHandlers := make([]task.TypedHandler[T], 0, len(handlers))
+		for _, handler := range handlers {
+			typedHandlers = append(typedHandlers, task.TypedHandler[T](newBatchHandler[T](config, handler)))
+		}
+
+		handlers := RegisterTaskHandlers[T](typedHandlers...)()
+		handlers.BatchSize = config.Size
+		return handlers
 	}
 }
 
@@ -7,7 +7,17 @@ import (
 	pkgstrings "github.com/klwxsrx/go-service-template/pkg/strings"
 )
 
+const (
+	// TopicWildcardTag matches exactly one tag of the topic, e.g. domain-event.*.user-aggregate
+	TopicWildcardTag = "*"
+	// TopicWildcardTail matches one or more trailing tags of the topic, e.g. domain-event.user-domain.>
+	TopicWildcardTail = ">"
+
+	topicTagSeparator = "."
+)
+
 type (
+	// Topic is either the concrete topic name or the pattern with TopicWildcardTag and TopicWildcardTail tags
 	Topic              string
 	TopicBuilderOption func(*topicBuilder)
 
@@ -20,15 +30,43 @@ type (
 	}
 )
 
-func (b *topicBuilder) Build() Topic {
-	const separator = '.'
+// IsPattern reports whether the topic has wildcard tags, see Match
+func (t Topic) IsPattern() bool {
+	for _, tag := range strings.Split(string(t), topicTagSeparator) {
+		if tag == TopicWildcardTag || tag == TopicWildcardTail {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Match reports whether the concrete topic matches the topic pattern, the topic without wildcards matches itself only
+func (t Topic) Match(topic Topic) bool {
+	patternTags := strings.Split(string(t), topicTagSeparator)
+	topicTags := strings.Split(string(topic), topicTagSeparator)
+	for i, patternTag := range patternTags {
+		if patternTag == TopicWildcardTail {
+			return i == len(patternTags)-1 && i < len(topicTags)
+		}
+		if i >= len(topicTags) {
+			return false
+		}
+		if patternTag != TopicWildcardTag && patternTag != topicTags[i] {
+			return false
+		}
+	}
+
+	return len(patternTags) == len(topicTags)
+}
 
+func (b *topicBuilder) Build() Topic {
 	sb := strings.Builder{}
 	sb.WriteString(b.baseName)
 
 	addTagIfNotEmpty := func(tag string) {
 		if tag != "" {
-			sb.WriteRune(separator)
+			sb.WriteString(topicTagSeparator)
 			sb.WriteString(tag)
 		}
 	}
@@ -74,6 +112,14 @@ func WithTopicCustomTags(tags ...string) TopicBuilderOption {
 	}
 }
 
+// WithTopicWildcardTail builds the topic pattern matching all the topics having the preceding tags,
+// e.g. NewTopic("domain-event", WithTopicDomainName("user"), WithTopicWildcardTail())
+func WithTopicWildcardTail() TopicBuilderOption {
+	return func(builder *topicBuilder) {
+		builder.customTags = append(builder.customTags, TopicWildcardTail)
+	}
+}
+
 func NewTopic(baseName string, opts ...TopicBuilderOption) Topic {
 	builder := topicBuilder{baseName: pkgstrings.ToKebabCase(baseName)}
 	for _, opt := range opts {
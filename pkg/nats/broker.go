@@ -0,0 +1,586 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/klwxsrx/go-service-template/pkg/log"
+	"github.com/klwxsrx/go-service-template/pkg/message"
+)
+
+const (
+	// MessageKeyHeader carries message.Message.Key, consumers with message.WithHandlerKeyOrdering process the same keys in order
+	MessageKeyHeader = "Message-Key"
+	// MessageHeaderPrefix prefixes the message.Message.Headers keys within the nats message headers
+	MessageHeaderPrefix = "Message-Header-"
+
+	defaultStreamName       = "messages"
+	defaultSubjectPrefix    = "message"
+	defaultAckWait          = 30 * time.Second
+	defaultMaxAckPending    = 1000
+	defaultNackInterval     = time.Second
+	defaultNackMaxInterval  = time.Hour
+	defaultOperationTimeout = 10 * time.Second
+)
+
+type (
+	BrokerOption func(*Broker)
+
+	// Broker maps message.Topic to the subject within the single JetStream stream
+	// and message.Subscriber to the durable pull consumer filtered by the topic subject
+	Broker struct {
+		StreamName    string
+		SubjectPrefix string
+		AckWait       time.Duration
+		MaxAckPending int
+		// NackDelay returns the redelivery delay of the negatively acknowledged message delivered the specified number of times
+		NackDelay message.StorageNackDelay
+		// ConsumeRetry creates the backoff of every consumer, it delays pulling the messages after the failures
+		ConsumeRetry func() backoff.BackOff
+		// OnConsumeError is called when the consumer fails to pull the messages, the pulling is retried with ConsumeRetry
+		OnConsumeError []func(context.Context, message.Topic, message.Subscriber, error)
+
+		conn      *nats.Conn
+		js        jetstream.JetStream
+		mutex     *sync.Mutex
+		consumers map[consumerKey]*consumer
+	}
+
+	consumer struct {
+		topic      message.Topic
+		subscriber message.Subscriber
+		impl       jetstream.Consumer
+		create     func(context.Context) (jetstream.Consumer, error)
+		nackDelay  message.StorageNackDelay
+		retry      backoff.BackOff
+		onError    []func(context.Context, message.Topic, message.Subscriber, error)
+		messagesCh chan *message.ConsumerMessage
+		startOnce  *sync.Once
+		closeOnce  *sync.Once
+		done       chan struct{}
+		stopped    chan struct{}
+		iterator   jetstream.MessagesContext
+		mutex      *sync.Mutex
+	}
+
+	consumerKey struct {
+		Topic      message.Topic
+		Subscriber message.Subscriber
+	}
+
+	msgContextKey struct{}
+)
+
+func NewBroker(ctx context.Context, url string, opts ...BrokerOption) (*Broker, error) {
+	b := &Broker{
+		StreamName:    defaultStreamName,
+		SubjectPrefix: defaultSubjectPrefix,
+		AckWait:       defaultAckWait,
+		MaxAckPending: defaultMaxAckPending,
+		NackDelay:     message.ExponentialStorageNackDelay(defaultNackInterval, defaultNackMaxInterval),
+		ConsumeRetry: func() backoff.BackOff {
+			return backoff.NewExponentialBackOff(
+				backoff.WithInitialInterval(100*time.Millisecond),
+				backoff.WithMultiplier(2),
+				backoff.WithMaxInterval(time.Minute),
+				backoff.WithMaxElapsedTime(0),
+			)
+		},
+		OnConsumeError: nil,
+
+		conn:      nil,
+		js:        nil,
+		mutex:     &sync.Mutex{},
+		consumers: make(map[consumerKey]*consumer),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	conn, err := nats.Connect(url)
+	if err != nil {
+		return nil, fmt.Errorf("connect to nats: %w", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("init jetstream: %w", err)
+	}
+
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     b.StreamName,
+		Subjects: []string{fmt.Sprintf("%s.>", b.SubjectPrefix)},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("create stream %s: %w", b.StreamName, err)
+	}
+
+	b.conn = conn
+	b.js = js
+	return b, nil
+}
+
+func (b *Broker) Consumer(topic message.Topic, subscriber message.Subscriber) (message.Consumer[message.AckNackStrategy], error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	key := consumerKey{Topic: topic, Subscriber: subscriber}
+	if _, ok := b.consumers[key]; ok {
+		return nil, fmt.Errorf("consumer for topic %s by %s already exists, only one is supported at a time", topic, subscriber)
+	}
+
+	create := func(ctx context.Context) (jetstream.Consumer, error) {
+		return b.js.CreateOrUpdateConsumer(ctx, b.StreamName, jetstream.ConsumerConfig{
+			Durable:       durableName(topic, subscriber),
+			FilterSubject: b.subject(topic),
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			AckWait:       b.AckWait,
+			MaxAckPending: b.MaxAckPending,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+
+	impl, err := create(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("create durable consumer for topic %s by %s: %w", topic, subscriber, err)
+	}
+
+	c := &consumer{
+		topic:      topic,
+		subscriber: subscriber,
+		impl:       impl,
+		create:     create,
+		nackDelay:  b.NackDelay,
+		retry:      b.ConsumeRetry(),
+		onError:    b.OnConsumeError,
+		messagesCh: make(chan *message.ConsumerMessage),
+		startOnce:  &sync.Once{},
+		closeOnce:  &sync.Once{},
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		iterator:   nil,
+		mutex:      &sync.Mutex{},
+	}
+	b.consumers[key] = c
+
+	return c, nil
+}
+
+// Topics returns the topics having messages in the stream
+func (b *Broker) Topics(ctx context.Context) ([]message.Topic, error) {
+	stream, err := b.js.Stream(ctx, b.StreamName)
+	if err != nil {
+		return nil, fmt.Errorf("get stream %s: %w", b.StreamName, err)
+	}
+
+	info, err := stream.Info(ctx, jetstream.WithSubjectFilter(b.subject(message.TopicWildcardTail)))
+	if err != nil {
+		return nil, fmt.Errorf("get stream %s info: %w", b.StreamName, err)
+	}
+
+	topics := make([]message.Topic, 0, len(info.State.Subjects))
+	for subject := range info.State.Subjects {
+		topics = append(topics, message.Topic(strings.TrimPrefix(subject, b.SubjectPrefix+".")))
+	}
+
+	return topics, nil
+}
+
+func (b *Broker) Produce(ctx context.Context, msg *message.Message) error {
+	_, err := b.js.PublishMsg(ctx, b.natsMessage(msg), jetstream.WithMsgID(msg.ID.String()))
+	if err != nil {
+		return fmt.Errorf("publish message %v to %s: %w", msg.ID, msg.Topic, err)
+	}
+
+	return nil
+}
+
+func (b *Broker) ProduceBatch(ctx context.Context, msgs []*message.Message) error {
+	futures := make([]jetstream.PubAckFuture, 0, len(msgs))
+	for _, msg := range msgs {
+		future, err := b.js.PublishMsgAsync(b.natsMessage(msg), jetstream.WithMsgID(msg.ID.String()))
+		if err != nil {
+			return fmt.Errorf("publish message %v to %s: %w", msg.ID, msg.Topic, err)
+		}
+
+		futures = append(futures, future)
+	}
+
+	for i, future := range futures {
+		select {
+		case <-future.Ok():
+		case err := <-future.Err():
+			return fmt.Errorf("publish message %v to %s: %w", msgs[i].ID, msgs[i].Topic, err)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (b *Broker) Close() error {
+	b.mutex.Lock()
+	consumers := make([]*consumer, 0, len(b.consumers))
+	for _, c := range b.consumers {
+		consumers = append(consumers, c)
+	}
+	b.mutex.Unlock()
+
+	for _, c := range consumers {
+		_ = c.Close()
+	}
+
+	err := b.conn.Drain()
+	if err != nil {
+		return fmt.Errorf("drain nats connection: %w", err)
+	}
+
+	return nil
+}
+
+func (b *Broker) subject(topic message.Topic) string {
+	return fmt.Sprintf("%s.%s", b.SubjectPrefix, topic)
+}
+
+func (b *Broker) natsMessage(msg *message.Message) *nats.Msg {
+	natsMsg := nats.NewMsg(b.subject(msg.Topic))
+	natsMsg.Data = msg.Payload
+	if msg.Key != "" {
+		natsMsg.Header.Set(MessageKeyHeader, msg.Key)
+	}
+	for key, value := range msg.Headers {
+		natsMsg.Header.Set(MessageHeaderPrefix+key, value)
+	}
+
+	return natsMsg
+}
+
+func (c *consumer) Topic() message.Topic {
+	return c.topic
+}
+
+func (c *consumer) Subscriber() message.Subscriber {
+	return c.subscriber
+}
+
+func (c *consumer) Messages() <-chan *message.ConsumerMessage {
+	c.startOnce.Do(func() {
+		go c.consume()
+	})
+
+	return c.messagesCh
+}
+
+func (c *consumer) Acknowledge() message.AckNackStrategy {
+	return c
+}
+
+func (c *consumer) Ack(ctx context.Context, msg *message.ConsumerMessage) error {
+	natsMsg, err := getNatsMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	err = natsMsg.DoubleAck(ctx)
+	if err != nil {
+		return fmt.Errorf("ack message %v: %w", msg.Message.ID, err)
+	}
+
+	return nil
+}
+
+func (c *consumer) Nack(_ context.Context, msg *message.ConsumerMessage, _ error) error {
+	natsMsg, err := getNatsMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	deliveries := 1
+	if meta, err := natsMsg.Metadata(); err == nil {
+		deliveries = int(meta.NumDelivered)
+	}
+
+	err = natsMsg.NakWithDelay(c.nackDelay(deliveries))
+	if err != nil {
+		return fmt.Errorf("nack message %v: %w", msg.Message.ID, err)
+	}
+
+	return nil
+}
+
+func (c *consumer) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+
+		c.mutex.Lock()
+		iterator := c.iterator
+		c.mutex.Unlock()
+		if iterator != nil {
+			iterator.Stop()
+		}
+
+		started := true
+		c.startOnce.Do(func() {
+			started = false
+		})
+		if started {
+			<-c.stopped
+		}
+
+		close(c.messagesCh)
+	})
+
+	return nil
+}
+
+func (c *consumer) consume() {
+	defer close(c.stopped)
+
+	for {
+		iterator, ok := c.startPulling()
+		if !ok {
+			return
+		}
+
+		if !c.pull(iterator) {
+			return
+		}
+
+		// the iterator is closed by the server, e.g. the durable consumer was deleted, so the consumer is recreated
+		if !c.waitRetry(errors.New("messages iterator closed unexpectedly")) {
+			return
+		}
+		c.impl = nil
+	}
+}
+
+// startPulling returns false if the consumer was closed before the iterator started
+func (c *consumer) startPulling() (jetstream.MessagesContext, bool) {
+	for {
+		iterator, err := c.messages()
+		if err != nil {
+			if !c.waitRetry(fmt.Errorf("start pulling messages: %w", err)) {
+				return nil, false
+			}
+			continue
+		}
+
+		c.mutex.Lock()
+		c.iterator = iterator
+		c.mutex.Unlock()
+
+		select {
+		case <-c.done:
+			iterator.Stop()
+			return nil, false
+		default:
+			return iterator, true
+		}
+	}
+}
+
+func (c *consumer) messages() (jetstream.MessagesContext, error) {
+	if c.impl == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+		defer cancel()
+
+		impl, err := c.create(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("create durable consumer: %w", err)
+		}
+		c.impl = impl
+	}
+
+	return c.impl.Messages()
+}
+
+// pull returns false if the consumer was closed, true if the iterator was closed by the server
+func (c *consumer) pull(iterator jetstream.MessagesContext) bool {
+	for {
+		natsMsg, err := iterator.Next()
+		if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+			select {
+			case <-c.done:
+				return false
+			default:
+				return true
+			}
+		}
+		if err != nil {
+			if !c.waitRetry(fmt.Errorf("pull message: %w", err)) {
+				iterator.Stop()
+				return false
+			}
+			continue
+		}
+		c.retry.Reset()
+
+		msg, err := newMessage(c.topic, natsMsg)
+		if err != nil {
+			_ = natsMsg.Term()
+			continue
+		}
+
+		select {
+		case c.messagesCh <- &message.ConsumerMessage{
+			Context:       context.WithValue(context.Background(), msgContextKey{}, natsMsg),
+			Message:       *msg,
+			Attempts:      previousDeliveries(natsMsg),
+			FirstFailedAt: nil,
+		}:
+		case <-c.done:
+			_ = natsMsg.Nak()
+			return false
+		}
+	}
+}
+
+// waitRetry reports the error and waits for the next attempt, returns false if the consumer was closed meanwhile
+func (c *consumer) waitRetry(err error) bool {
+	for _, fn := range c.onError {
+		fn(context.Background(), c.topic, c.subscriber, err)
+	}
+
+	delay := c.retry.NextBackOff()
+	if delay == backoff.Stop {
+		c.retry.Reset()
+		delay = c.retry.NextBackOff()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func newMessage(topic message.Topic, natsMsg jetstream.Msg) (*message.Message, error) {
+	id, err := uuid.Parse(natsMsg.Headers().Get(jetstream.MsgIDHeader))
+	if err != nil {
+		return nil, fmt.Errorf("parse message id: %w", err)
+	}
+
+	var headers map[string]string
+	for key, values := range natsMsg.Headers() {
+		name, ok := strings.CutPrefix(key, MessageHeaderPrefix)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[name] = values[0]
+	}
+
+	return &message.Message{
+		ID:      id,
+		Topic:   topic,
+		Key:     natsMsg.Headers().Get(MessageKeyHeader),
+		Payload: natsMsg.Data(),
+		Headers: headers,
+	}, nil
+}
+
+func getNatsMessage(msg *message.ConsumerMessage) (jetstream.Msg, error) {
+	natsMsg, ok := msg.Context.Value(msgContextKey{}).(jetstream.Msg)
+	if !ok {
+		return nil, fmt.Errorf("message %v wasn't consumed from nats", msg.Message.ID)
+	}
+
+	return natsMsg, nil
+}
+
+// previousDeliveries counts the deliveries not acknowledged before, nats doesn't track the time of the first one
+func previousDeliveries(natsMsg jetstream.Msg) int {
+	meta, err := natsMsg.Metadata()
+	if err != nil || meta.NumDelivered == 0 {
+		return 0
+	}
+
+	return int(meta.NumDelivered - 1) //nolint:gosec
+}
+
+// durableName builds the consumer name, the names must not contain dots, wildcards and whitespaces
+func durableName(topic message.Topic, subscriber message.Subscriber) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '/', '\\':
+			return '_'
+		default:
+			return r
+		}
+	}, fmt.Sprintf("%s__%s", subscriber, topic))
+}
+
+func WithStreamName(name string) BrokerOption {
+	return func(b *Broker) {
+		b.StreamName = name
+	}
+}
+
+func WithSubjectPrefix(prefix string) BrokerOption {
+	return func(b *Broker) {
+		b.SubjectPrefix = prefix
+	}
+}
+
+func WithAckWait(ackWait time.Duration) BrokerOption {
+	return func(b *Broker) {
+		b.AckWait = ackWait
+	}
+}
+
+func WithMaxAckPending(maxAckPending int) BrokerOption {
+	return func(b *Broker) {
+		b.MaxAckPending = maxAckPending
+	}
+}
+
+func WithNackDelay(delay message.StorageNackDelay) BrokerOption {
+	return func(b *Broker) {
+		b.NackDelay = delay
+	}
+}
+
+func WithConsumeRetry(retry func() backoff.BackOff) BrokerOption {
+	return func(b *Broker) {
+		b.ConsumeRetry = retry
+	}
+}
+
+func WithLogging(logger log.Logger, errorLevel log.Level) BrokerOption {
+	return func(b *Broker) {
+		b.OnConsumeError = append(b.OnConsumeError, func(
+			ctx context.Context,
+			topic message.Topic,
+			subscriber message.Subscriber,
+			err error,
+		) {
+			logger.
+				With(log.Fields{
+					"topic":      topic,
+					"subscriber": subscriber,
+				}).
+				WithError(err).
+				Log(ctx, errorLevel, "nats consumer failed to pull messages")
+		})
+	}
+}
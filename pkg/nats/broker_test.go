@@ -0,0 +1,145 @@
+package nats
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+
+	"github.com/klwxsrx/go-service-template/pkg/message"
+)
+
+const (
+	testTopic      message.Topic      = "test.topic"
+	testSubscriber message.Subscriber = "test-subscriber"
+	testTimeout                       = 5 * time.Second
+	testRetryDelay                    = 50 * time.Millisecond
+)
+
+func TestConsumerRecoversFromPullErrors(t *testing.T) {
+	broker := newTestBroker(t)
+
+	var (
+		mutex    sync.Mutex
+		pullErrs []error
+	)
+	broker.OnConsumeError = append(broker.OnConsumeError, func(
+		_ context.Context,
+		topic message.Topic,
+		subscriber message.Subscriber,
+		err error,
+	) {
+		if topic != testTopic || subscriber != testSubscriber {
+			t.Errorf("unexpected consumer %s by %s", topic, subscriber)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		pullErrs = append(pullErrs, err)
+	})
+
+	consumer, err := broker.Consumer(testTopic, testSubscriber)
+	if err != nil {
+		t.Fatalf("create consumer: %v", err)
+	}
+
+	produceAndConsume(t, broker, consumer)
+
+	err = broker.js.DeleteConsumer(context.Background(), broker.StreamName, durableName(testTopic, testSubscriber))
+	if err != nil {
+		t.Fatalf("delete durable consumer: %v", err)
+	}
+
+	produceAndConsume(t, broker, consumer)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(pullErrs) == 0 {
+		t.Fatal("pull errors weren't reported")
+	}
+	// the retries are delayed, so the failing consumer doesn't spin
+	if maxErrs := int(testTimeout / testRetryDelay); len(pullErrs) > maxErrs {
+		t.Fatalf("got %d pull errors, expected at most %d", len(pullErrs), maxErrs)
+	}
+}
+
+func newTestBroker(t *testing.T) *Broker {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("create nats server: %v", err)
+	}
+
+	go srv.Start()
+	t.Cleanup(srv.Shutdown)
+	if !srv.ReadyForConnections(testTimeout) {
+		t.Fatal("nats server isn't ready for connections")
+	}
+
+	broker, err := NewBroker(
+		context.Background(),
+		srv.ClientURL(),
+		WithConsumeRetry(func() backoff.BackOff {
+			return backoff.NewConstantBackOff(testRetryDelay)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("create broker: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = broker.Close()
+	})
+
+	return broker
+}
+
+func produceAndConsume(t *testing.T, broker *Broker, consumer message.Consumer[message.AckNackStrategy]) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	produced := message.Message{
+		ID:      uuid.New(),
+		Topic:   testTopic,
+		Key:     "",
+		Payload: []byte("payload"),
+		Headers: nil,
+	}
+	err := broker.Produce(ctx, &produced)
+	if err != nil {
+		t.Fatalf("produce message: %v", err)
+	}
+
+	// the recreated durable consumer delivers the stream from the beginning, so the older messages are skipped
+	for {
+		select {
+		case msg, ok := <-consumer.Messages():
+			if !ok {
+				t.Fatal("consumer messages channel closed")
+			}
+
+			err = consumer.Acknowledge().Ack(ctx, msg)
+			if err != nil {
+				t.Fatalf("ack message: %v", err)
+			}
+			if msg.Message.ID == produced.ID {
+				return
+			}
+		case <-ctx.Done():
+			t.Fatalf("message %v wasn't consumed", produced.ID)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+
+	"github.com/klwxsrx/go-service-template/pkg/eventstore"
+)
+
+type EventStoreStorage struct {
+	db Client
+}
+
+func NewEventStoreStorage(db Client) eventstore.Storage {
+	return EventStoreStorage{db: db}
+}
+
+func (s EventStoreStorage) Append(
+	ctx context.Context,
+	aggregateName string,
+	aggregateID uuid.UUID,
+	expectedVersion int,
+	events []eventstore.StoredEvent,
+) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	// the stream version row serializes the concurrent appends, the stale writer updates nothing
+	newVersion := expectedVersion + len(events)
+	var streamQuery sq.Sqlizer = sq.
+		Update("event_store_stream").
+		Set("version", newVersion).
+		Where(sq.Eq{"aggregate_id": aggregateID}).
+		Where(sq.Eq{"version": expectedVersion})
+	if expectedVersion == 0 {
+		streamQuery = sq.
+			Insert("event_store_stream").
+			Columns("aggregate_id", "aggregate_name", "version").
+			Values(aggregateID, aggregateName, newVersion).
+			Suffix("on conflict (aggregate_id) do nothing")
+	}
+
+	query, args, err := streamQuery.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %w", err)
+	}
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("update stream query: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %v expected version %d", eventstore.ErrConcurrentModification, aggregateID, expectedVersion)
+	}
+
+	qb := sq.
+		Insert("event_store_event").
+		Columns("aggregate_id", "version", "event_id", "event_type", "payload", "recorded_at")
+	for _, evt := range events {
+		// jsonb value is passed as a string to avoid the bytea encoding
+		qb = qb.Values(aggregateID, evt.Version, evt.ID, evt.Type, string(evt.Payload), evt.RecordedAt)
+	}
+
+	query, args, err = qb.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("insert events query: %w", err)
+	}
+
+	return nil
+}
+
+func (s EventStoreStorage) Load(ctx context.Context, aggregateID uuid.UUID, afterVersion int) ([]eventstore.StoredEvent, error) {
+	query, args, err := sq.
+		Select("event_id", "event_type", "version", "payload", "recorded_at").
+		From("event_store_event").
+		Where(sq.Eq{"aggregate_id": aggregateID}).
+		Where(sq.Gt{"version": afterVersion}).
+		OrderBy("version").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build sql: %w", err)
+	}
+
+	var sqlxResult []sqlxStoredEvent
+	err = s.db.SelectContext(ctx, &sqlxResult, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("select query: %w", err)
+	}
+
+	result := make([]eventstore.StoredEvent, 0, len(sqlxResult))
+	for _, evt := range sqlxResult {
+		result = append(result, eventstore.StoredEvent{
+			ID:         evt.ID,
+			Type:       evt.Type,
+			Version:    evt.Version,
+			Payload:    evt.Payload,
+			RecordedAt: evt.RecordedAt,
+		})
+	}
+
+	return result, nil
+}
+
+func (s EventStoreStorage) LoadSnapshot(ctx context.Context, aggregateID uuid.UUID) (eventstore.Snapshot, error) {
+	query, args, err := sq.
+		Select("version", "payload").
+		From("event_store_snapshot").
+		Where(sq.Eq{"aggregate_id": aggregateID}).
+		ToSql()
+	if err != nil {
+		return eventstore.Snapshot{}, fmt.Errorf("build sql: %w", err)
+	}
+
+	var snapshot sqlxSnapshot
+	err = s.db.GetContext(ctx, &snapshot, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return eventstore.Snapshot{}, eventstore.ErrSnapshotNotFound
+	}
+	if err != nil {
+		return eventstore.Snapshot{}, fmt.Errorf("select query: %w", err)
+	}
+
+	return eventstore.Snapshot{
+		AggregateID: aggregateID,
+		Version:     snapshot.Version,
+		Payload:     snapshot.Payload,
+	}, nil
+}
+
+func (s EventStoreStorage) SaveSnapshot(ctx context.Context, snapshot eventstore.Snapshot) error {
+	query, args, err := sq.
+		Insert("event_store_snapshot").
+		Columns("aggregate_id", "version", "payload").
+		Values(snapshot.AggregateID, snapshot.Version, string(snapshot.Payload)).
+		Suffix(`on conflict (aggregate_id) do update set
+			version = excluded.version,
+			payload = excluded.payload
+			where event_store_snapshot.version < excluded.version
+		`).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("upsert query: %w", err)
+	}
+
+	return nil
+}
+
+func EventStoreMigrations() ([]Migration, error) {
+	return []Migration{
+		{
+			ID: "0000-00-00-001-create-event-store-tables",
+			SQL: `
+				create table if not exists event_store_stream (
+					aggregate_id   uuid    not null primary key,
+					aggregate_name text    not null,
+					version        integer not null
+				);
+
+				create table if not exists event_store_event (
+					aggregate_id uuid        not null,
+					version      integer     not null,
+					event_id     uuid        not null unique,
+					event_type   text        not null,
+					payload      jsonb       not null,
+					recorded_at  timestamptz not null,
+					primary key (aggregate_id, version),
+					foreign key (aggregate_id) references event_store_stream (aggregate_id)
+				);
+
+				create table if not exists event_store_snapshot (
+					aggregate_id uuid    not null primary key,
+					version      integer not null,
+					payload      jsonb   not null,
+					foreign key (aggregate_id) references event_store_stream (aggregate_id)
+				);
+			`,
+		},
+	}, nil
+}
+
+type sqlxStoredEvent struct {
+	ID         uuid.UUID `db:"event_id"`
+	Type       string    `db:"event_type"`
+	Version    int       `db:"version"`
+	Payload    []byte    `db:"payload"`
+	RecordedAt time.Time `db:"recorded_at"`
+}
+
+type sqlxSnapshot struct {
+	Version int    `db:"version"`
+	Payload []byte `db:"payload"`
+}
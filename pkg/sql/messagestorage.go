@@ -2,6 +2,7 @@ package sql
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -12,44 +13,44 @@ import (
 	"github.com/klwxsrx/go-service-template/pkg/message"
 )
 
-const messageStorageLockName = "message_storage"
+const (
+	messageStorageLockName             = "message_storage"
+	defaultMessageStoragePriorityAging = time.Minute
+)
 
-type MessageStorage struct {
-	db Client
-}
+type (
+	MessageStorageOption func(*MessageStorage)
 
-func NewMessageStorage(db Client) *MessageStorage {
-	return &MessageStorage{
-		db: db,
-	}
-}
+	MessageStorage struct {
+		// PriorityAging is the waiting time equal to one message.Priority level. The message is found as if it was
+		// scheduled earlier by its priority multiplied by PriorityAging, so the low priority messages aren't starved
+		PriorityAging time.Duration
 
-func (s MessageStorage) Lock(ctx context.Context, extraKeys ...string) (context.Context, func() error, error) {
-	if len(extraKeys) == 0 {
-		return withSessionLevelLock(ctx, messageStorageLockName, s.db)
+		db Client
 	}
+)
 
-	sb := strings.Builder{}
-	sb.WriteString(messageStorageLockName)
-	for _, key := range extraKeys {
-		sb.WriteString("_")
-		sb.WriteString(key)
+func NewMessageStorage(db Client, opts ...MessageStorageOption) *MessageStorage {
+	s := &MessageStorage{
+		PriorityAging: defaultMessageStoragePriorityAging,
+
+		db: db,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 
-	return withSessionLevelLock(ctx, sb.String(), s.db)
+	return s
 }
 
-func (s MessageStorage) Find(ctx context.Context, spec *message.StorageSpecification) ([]message.Message, error) {
-	qb := sq.
-		Select("id", "topic", "key", "payload").
-		From("message_storage").
-		Where(sq.LtOrEq{"scheduled_at": spec.ScheduledAtBefore}).
-		OrderBy("scheduled_at")
-	if len(spec.IDsExcluded) > 0 {
-		qb = qb.Where(sq.NotEq{"id": spec.IDsExcluded})
-	}
-	if len(spec.Topics) > 0 {
-		qb = qb.Where(sq.Eq{"topic": spec.Topics})
+func (s MessageStorage) Lock(ctx context.Context, extraKeys ...string) (context.Context, func() error, error) {
+	return withSessionLevelLock(ctx, messageStorageLockKey(extraKeys...), s.db)
+}
+
+func (s MessageStorage) Find(ctx context.Context, spec *message.StorageSpecification) ([]message.StoredMessage, error) {
+	qb := s.buildFindQuery(spec)
+	if spec.Subscriber != "" {
+		qb = s.buildFindSubscriberQuery(spec)
 	}
 	if spec.Limit > 0 {
 		qb = qb.Limit(uint64(spec.Limit))
@@ -66,27 +67,199 @@ func (s MessageStorage) Find(ctx context.Context, spec *message.StorageSpecifica
 		return nil, fmt.Errorf("select query: %w", err)
 	}
 
-	result := make([]message.Message, 0, len(sqlxResult))
+	result := make([]message.StoredMessage, 0, len(sqlxResult))
 	for _, sqlxMsg := range sqlxResult {
-		result = append(result, message.Message{
-			ID:      sqlxMsg.ID,
-			Topic:   message.Topic(sqlxMsg.Topic),
-			Key:     sqlxMsg.Key,
-			Payload: sqlxMsg.Payload,
+		var headers map[string]string
+		if len(sqlxMsg.Headers) > 0 {
+			err = json.Unmarshal(sqlxMsg.Headers, &headers)
+			if err != nil {
+				return nil, fmt.Errorf("decode message %v headers: %w", sqlxMsg.ID, err)
+			}
+		}
+
+		result = append(result, message.StoredMessage{
+			Message: message.Message{
+				ID:      sqlxMsg.ID,
+				Topic:   message.Topic(sqlxMsg.Topic),
+				Key:     sqlxMsg.Key,
+				Payload: sqlxMsg.Payload,
+				Headers: headers,
+			},
+			ScheduledAt:   sqlxMsg.ScheduledAt,
+			Attempts:      sqlxMsg.Attempts,
+			LastError:     sqlxMsg.LastError,
+			FirstFailedAt: sqlxMsg.FirstFailedAt,
+		})
+	}
+
+	return result, nil
+}
+
+func (s MessageStorage) Stats(ctx context.Context, now time.Time) ([]message.StorageTopicStats, error) {
+	// the delivery state is per subscriber, as in buildFindSubscriberQuery
+	const scheduledAt = "coalesce(d.scheduled_at, m.scheduled_at)"
+
+	query, args, err := sq.
+		Select("m.topic", "coalesce(s.subscriber, '') as subscriber").
+		Column(sq.Expr("count(*) filter (where "+scheduledAt+" <= ?) as pending", now)).
+		Column(sq.Expr("min("+scheduledAt+") filter (where "+scheduledAt+" <= ?) as oldest_pending_scheduled_at", now)).
+		Column(sq.Expr("count(*) filter (where "+scheduledAt+" > ?) as scheduled", now)).
+		From("message_storage m").
+		LeftJoin("message_storage_subscription s on s.topic = m.topic").
+		LeftJoin("message_storage_delivery d on d.id = m.id and d.topic = m.topic and d.subscriber = s.subscriber").
+		Where("d.acknowledged_at is null").
+		GroupBy("m.topic", "s.subscriber").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build sql: %w", err)
+	}
+
+	var sqlxResult []sqlxTopicStats
+	err = s.db.SelectContext(ctx, &sqlxResult, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("select query: %w", err)
+	}
+
+	result := make([]message.StorageTopicStats, 0, len(sqlxResult))
+	for _, stats := range sqlxResult {
+		result = append(result, message.StorageTopicStats{
+			Topic:                    message.Topic(stats.Topic),
+			Subscriber:               message.Subscriber(stats.Subscriber),
+			Pending:                  stats.Pending,
+			OldestPendingScheduledAt: stats.OldestPendingScheduledAt,
+			Scheduled:                stats.Scheduled,
 		})
 	}
 
 	return result, nil
 }
 
+func (s MessageStorage) Topics(ctx context.Context) ([]message.Topic, error) {
+	query, args, err := sq.
+		Select("topic").
+		From("message_storage").
+		Suffix("union select topic from message_storage_subscription").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build sql: %w", err)
+	}
+
+	var sqlxResult []string
+	err = s.db.SelectContext(ctx, &sqlxResult, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("select query: %w", err)
+	}
+
+	result := make([]message.Topic, 0, len(sqlxResult))
+	for _, topic := range sqlxResult {
+		result = append(result, message.Topic(topic))
+	}
+
+	return result, nil
+}
+
+func (s MessageStorage) buildFindQuery(spec *message.StorageSpecification) sq.SelectBuilder {
+	// the failure state is of the subscriber having the most failed attempts, see message.StoredMessage
+	qb := sq.
+		Select(
+			"m.id", "m.topic", "m.key", "m.payload", "m.headers", "m.scheduled_at",
+			"coalesce(d.attempts, 0) as attempts",
+			"d.last_error",
+			"d.first_failed_at",
+		).
+		From("message_storage m").
+		JoinClause(`left join lateral (
+			select attempts, last_error, first_failed_at from message_storage_delivery
+			where id = m.id and topic = m.topic and acknowledged_at is null
+			order by attempts desc
+			limit 1
+		) d on true`).
+		Where(sq.LtOrEq{"m.scheduled_at": spec.ScheduledAtBefore}).
+		OrderBy(s.priorityOrder("m.scheduled_at"), "m.scheduled_at", "m.sequence")
+	if len(spec.IDs) > 0 {
+		qb = qb.Where(sq.Eq{"m.id": spec.IDs})
+	}
+	if len(spec.IDsExcluded) > 0 {
+		qb = qb.Where(sq.NotEq{"m.id": spec.IDsExcluded})
+	}
+	if spec.FailedOnly {
+		qb = qb.Where("d.attempts > 0")
+	}
+	if len(spec.Topics) > 0 {
+		qb = qb.Where(sq.Eq{"m.topic": spec.Topics})
+	}
+	if spec.KeyOrdered {
+		qb = qb.Where(`(m.key = '' or not exists (
+			select 1 from message_storage prev
+			where prev.topic = m.topic and prev.key = m.key and prev.sequence < m.sequence
+		))`)
+	}
+
+	return qb
+}
+
+func (s MessageStorage) buildFindSubscriberQuery(spec *message.StorageSpecification) sq.SelectBuilder {
+	const scheduledAt = "coalesce(d.scheduled_at, m.scheduled_at)"
+
+	qb := sq.
+		Select(
+			"m.id", "m.topic", "m.key", "m.payload", "m.headers",
+			scheduledAt+" as scheduled_at",
+			"coalesce(d.attempts, 0) as attempts",
+			"d.last_error",
+			"d.first_failed_at",
+		).
+		From("message_storage m").
+		LeftJoin("message_storage_delivery d on d.id = m.id and d.topic = m.topic and d.subscriber = ?", spec.Subscriber).
+		Where("d.acknowledged_at is null").
+		Where(sq.LtOrEq{scheduledAt: spec.ScheduledAtBefore}).
+		OrderBy(s.priorityOrder(scheduledAt), scheduledAt, "m.sequence")
+	if len(spec.IDs) > 0 {
+		qb = qb.Where(sq.Eq{"m.id": spec.IDs})
+	}
+	if len(spec.IDsExcluded) > 0 {
+		qb = qb.Where(sq.NotEq{"m.id": spec.IDsExcluded})
+	}
+	if spec.FailedOnly {
+		qb = qb.Where("d.attempts > 0")
+	}
+	if len(spec.Topics) > 0 {
+		qb = qb.Where(sq.Eq{"m.topic": spec.Topics})
+	}
+	if spec.KeyOrdered {
+		qb = qb.Where(`(m.key = '' or not exists (
+			select 1 from message_storage prev
+			left join message_storage_delivery prev_d
+				on prev_d.id = prev.id and prev_d.topic = prev.topic and prev_d.subscriber = ?
+			where prev.topic = m.topic and prev.key = m.key and prev.sequence < m.sequence
+				and prev_d.acknowledged_at is null
+		))`, spec.Subscriber)
+	}
+
+	return qb
+}
+
+// priorityOrder moves the message earlier by its priority, it only affects the order of the already scheduled messages
+func (s MessageStorage) priorityOrder(scheduledAt string) string {
+	if s.PriorityAging <= 0 {
+		return "m.priority desc"
+	}
+
+	return fmt.Sprintf("%s - m.priority * interval '%d milliseconds'", scheduledAt, s.PriorityAging.Milliseconds())
+}
+
 func (s MessageStorage) Store(ctx context.Context, scheduledAt time.Time, msgs ...message.Message) error {
 	if len(msgs) == 0 {
 		return nil
 	}
 
-	qb := sq.Insert("message_storage").Columns("id", "topic", "key", "payload", "scheduled_at")
+	qb := sq.Insert("message_storage").Columns("id", "topic", "key", "payload", "headers", "priority", "scheduled_at")
 	for _, msg := range msgs {
-		qb = qb.Values(msg.ID, msg.Topic, msg.Key, msg.Payload, scheduledAt)
+		headers, err := encodeMessageHeaders(msg.Headers)
+		if err != nil {
+			return fmt.Errorf("encode message %v headers: %w", msg.ID, err)
+		}
+		qb = qb.Values(msg.ID, msg.Topic, msg.Key, msg.Payload, headers, message.GetMessagePriority(&msg), scheduledAt)
 	}
 	qb = qb.Suffix("on conflict (id, topic) do nothing")
 
@@ -100,9 +273,93 @@ func (s MessageStorage) Store(ctx context.Context, scheduledAt time.Time, msgs .
 		return fmt.Errorf("insert query: %w", err)
 	}
 
+	// postgresql delivers notifications only after the transaction is committed
+	err = notifyMessageStorage(ctx, s.db, scheduledAt, msgs)
+	if err != nil {
+		return fmt.Errorf("notify stored messages: %w", err)
+	}
+
+	return nil
+}
+
+func (s MessageStorage) StoreDeduplicated(
+	ctx context.Context,
+	scheduledAt time.Time,
+	deduplicationKey string,
+	msg message.Message,
+) error {
+	ctx, release, err := s.lockDeduplicationKey(ctx, msg.Topic, deduplicationKey)
+	if err != nil {
+		return fmt.Errorf("lock deduplication key: %w", err)
+	}
+	defer func() {
+		_ = release()
+	}()
+
+	// the replaced message is deleted with its delivery state, so the new one is delivered to all the subscribers.
+	// The replaced message could be already consumed at the moment, so both of them could be delivered
+	query, args, err := sq.
+		Delete("message_storage").
+		Where(sq.Eq{"topic": msg.Topic}).
+		Where(sq.Eq{"deduplication_key": deduplicationKey}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("delete query: %w", err)
+	}
+
+	headers, err := encodeMessageHeaders(msg.Headers)
+	if err != nil {
+		return fmt.Errorf("encode message %v headers: %w", msg.ID, err)
+	}
+
+	query, args, err = sq.
+		Insert("message_storage").
+		Columns("id", "topic", "key", "payload", "headers", "priority", "scheduled_at", "deduplication_key").
+		Values(msg.ID, msg.Topic, msg.Key, msg.Payload, headers, message.GetMessagePriority(&msg), scheduledAt, deduplicationKey).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("insert query: %w", err)
+	}
+
+	err = notifyMessageStorage(ctx, s.db, scheduledAt, []message.Message{msg})
+	if err != nil {
+		return fmt.Errorf("notify stored messages: %w", err)
+	}
+
 	return nil
 }
 
+// lockDeduplicationKey serializes the replacements of the same deduplication key, which otherwise race
+// on the unique index. Within a transaction the lock is held until the commit, so the replacement is visible
+// to the next one
+func (s MessageStorage) lockDeduplicationKey(
+	ctx context.Context,
+	topic message.Topic,
+	deduplicationKey string,
+) (context.Context, func() error, error) {
+	tx, ok := ctx.Value(dbTransactionContextKey).(ClientTx)
+	if !ok {
+		return s.Lock(ctx, string(topic), deduplicationKey)
+	}
+
+	err := withTransactionLevelLock(ctx, messageStorageLockKey(string(topic), deduplicationKey), false, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ctx, func() error { return nil }, nil
+}
+
 func (s MessageStorage) Delete(ctx context.Context, topic message.Topic, ids ...uuid.UUID) error {
 	if len(ids) == 0 {
 		return nil
@@ -125,6 +382,239 @@ func (s MessageStorage) Delete(ctx context.Context, topic message.Topic, ids ...
 	return nil
 }
 
+func (s MessageStorage) CancelByID(ctx context.Context, id uuid.UUID, topics ...message.Topic) error {
+	qb := sq.
+		Delete("message_storage").
+		Where(sq.Eq{"id": id})
+	if len(topics) > 0 {
+		qb = qb.Where(sq.Eq{"topic": topics})
+	}
+
+	query, args, err := qb.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %w", err)
+	}
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("delete query: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return message.ErrStorageMessageNotFound
+	}
+
+	return nil
+}
+
+func (s MessageStorage) RescheduleByID(
+	ctx context.Context,
+	id uuid.UUID,
+	scheduledAt time.Time,
+	topics ...message.Topic,
+) error {
+	// the expiry is shifted by the same interval, so the message TTL is counted from the new schedule time
+	qb := sq.
+		Update("message_storage").
+		Set("scheduled_at", scheduledAt).
+		Set("headers", sq.Expr(`case when headers ->> ?::text is null then headers else headers || jsonb_build_object(
+			?::text,
+			to_char(
+				((headers ->> ?::text)::timestamptz + (?::timestamptz - scheduled_at)) at time zone 'UTC',
+				'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'
+			)
+		) end`, message.ExpiresAtHeader, message.ExpiresAtHeader, message.ExpiresAtHeader, scheduledAt)).
+		Where(sq.Eq{"id": id}).
+		Suffix("returning topic")
+	if len(topics) > 0 {
+		qb = qb.Where(sq.Eq{"topic": topics})
+	}
+
+	query, args, err := qb.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %w", err)
+	}
+
+	var rescheduledTopics []message.Topic
+	err = s.db.SelectContext(ctx, &rescheduledTopics, query, args...)
+	if err != nil {
+		return fmt.Errorf("update query: %w", err)
+	}
+	if len(rescheduledTopics) == 0 {
+		return message.ErrStorageMessageNotFound
+	}
+
+	query, args, err = sq.
+		Update("message_storage_delivery").
+		Set("scheduled_at", scheduledAt).
+		Where(sq.Eq{"id": id}).
+		Where(sq.Eq{"topic": rescheduledTopics}).
+		Where("acknowledged_at is null").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("update delivery query: %w", err)
+	}
+
+	msgs := make([]message.Message, 0, len(rescheduledTopics))
+	for _, topic := range rescheduledTopics {
+		msgs = append(msgs, message.Message{ID: id, Topic: topic, Key: "", Payload: nil, Headers: nil})
+	}
+
+	err = notifyMessageStorage(ctx, s.db, scheduledAt, msgs)
+	if err != nil {
+		return fmt.Errorf("notify rescheduled messages: %w", err)
+	}
+
+	return nil
+}
+
+func (s MessageStorage) Subscribe(ctx context.Context, topic message.Topic, subscriber message.Subscriber) error {
+	query, args, err := sq.
+		Insert("message_storage_subscription").
+		Columns("topic", "subscriber").
+		Values(topic, subscriber).
+		Suffix("on conflict do nothing").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("insert query: %w", err)
+	}
+
+	return nil
+}
+
+func (s MessageStorage) Acknowledge(
+	ctx context.Context,
+	subscriber message.Subscriber,
+	topic message.Topic,
+	ids ...uuid.UUID,
+) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// the messages already deleted from the storage are skipped, so acknowledging them is a no-op
+	query, args, err := sq.
+		Insert("message_storage_delivery").
+		Columns("id", "topic", "subscriber", "scheduled_at", "acknowledged_at").
+		Select(sq.
+			Select("id", "topic").
+			Column("?", subscriber).
+			Column("now()").
+			Column("now()").
+			From("message_storage").
+			Where(sq.Eq{"topic": topic}).
+			Where(sq.Eq{"id": ids}).
+			PlaceholderFormat(sq.Question),
+		).
+		Suffix("on conflict (id, topic, subscriber) do update set acknowledged_at = excluded.acknowledged_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("insert delivery query: %w", err)
+	}
+
+	err = s.deleteAcknowledgedByAllSubscribers(ctx, topic, ids)
+	if err != nil {
+		return fmt.Errorf("delete acknowledged messages: %w", err)
+	}
+
+	return nil
+}
+
+func (s MessageStorage) RescheduleDelivery(
+	ctx context.Context,
+	subscriber message.Subscriber,
+	topic message.Topic,
+	id uuid.UUID,
+	scheduledAt time.Time,
+	failure *message.StorageDeliveryFailure,
+) error {
+	var (
+		attempts      int
+		lastError     *string
+		firstFailedAt *time.Time
+	)
+	if failure != nil {
+		errStr := failure.Err.Error()
+		attempts = failure.Attempts
+		lastError = &errStr
+		firstFailedAt = &failure.FirstFailedAt
+	}
+
+	query, args, err := sq.
+		Insert("message_storage_delivery").
+		Columns("id", "topic", "subscriber", "scheduled_at", "attempts", "last_error", "first_failed_at").
+		Values(id, topic, subscriber, scheduledAt, attempts, lastError, firstFailedAt).
+		Suffix(`on conflict (id, topic, subscriber) do update set
+			scheduled_at = excluded.scheduled_at,
+			attempts = greatest(message_storage_delivery.attempts, excluded.attempts),
+			last_error = coalesce(excluded.last_error, message_storage_delivery.last_error),
+			first_failed_at = least(message_storage_delivery.first_failed_at, excluded.first_failed_at)
+		`).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("upsert delivery query: %w", err)
+	}
+
+	return nil
+}
+
+func (s MessageStorage) deleteAcknowledgedByAllSubscribers(ctx context.Context, topic message.Topic, ids []uuid.UUID) error {
+	query, args, err := sq.
+		Delete("message_storage m").
+		Where(sq.Eq{"m.topic": topic}).
+		Where(sq.Eq{"m.id": ids}).
+		Where(`not exists (
+			select 1 from message_storage_subscription s
+			where s.topic = m.topic and not exists (
+				select 1 from message_storage_delivery d
+				where d.id = m.id and d.topic = m.topic and d.subscriber = s.subscriber and d.acknowledged_at is not null
+			)
+		)`).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("delete query: %w", err)
+	}
+
+	return nil
+}
+
+// WithMessageStoragePriorityAging sets MessageStorage.PriorityAging, zero value orders the messages
+// by priority first, so the low priority messages could starve
+func WithMessageStoragePriorityAging(aging time.Duration) MessageStorageOption {
+	return func(s *MessageStorage) {
+		s.PriorityAging = aging
+	}
+}
+
 func MessageStorageMigrations() ([]Migration, error) {
 	return []Migration{
 		{
@@ -142,12 +632,120 @@ func MessageStorageMigrations() ([]Migration, error) {
 				create index if not exists message_storage_scheduled_at_topic on message_storage(scheduled_at, topic);
 			`,
 		},
+		{
+			ID: "0000-00-00-002-add-message-storage-retry-state",
+			SQL: `
+				alter table message_storage
+					add column if not exists attempts        integer     not null default 0,
+					add column if not exists last_error      text,
+					add column if not exists first_failed_at timestamptz;
+			`,
+		},
+		{
+			ID: "0000-00-00-003-create-message-storage-delivery-tables",
+			SQL: `
+				create table if not exists message_storage_subscription (
+					topic      text not null,
+					subscriber text not null,
+					primary key (topic, subscriber)
+				);
+
+				create table if not exists message_storage_delivery (
+					id              uuid        not null,
+					topic           text        not null,
+					subscriber      text        not null,
+					scheduled_at    timestamptz not null,
+					attempts        integer     not null default 0,
+					last_error      text,
+					first_failed_at timestamptz,
+					acknowledged_at timestamptz,
+					primary key (id, topic, subscriber),
+					foreign key (id, topic) references message_storage (id, topic) on delete cascade
+				);
+			`,
+		},
+		{
+			ID: "0000-00-00-004-add-message-storage-sequence",
+			SQL: `
+				alter table message_storage add column if not exists sequence bigserial;
+
+				create index if not exists message_storage_topic_key_sequence on message_storage(topic, key, sequence);
+			`,
+		},
+		{
+			ID: "0000-00-00-005-add-message-storage-deduplication-key",
+			SQL: `
+				alter table message_storage add column if not exists deduplication_key text;
+
+				create unique index if not exists message_storage_topic_deduplication_key
+					on message_storage(topic, deduplication_key) where deduplication_key is not null;
+			`,
+		},
+		{
+			ID: "0000-00-00-006-add-message-storage-headers",
+			SQL: `
+				alter table message_storage add column if not exists headers jsonb not null default '{}';
+			`,
+		},
+		{
+			ID: "0000-00-00-007-add-message-storage-priority",
+			SQL: `
+				alter table message_storage add column if not exists priority integer not null default 0;
+			`,
+		},
+		{
+			ID: "0000-00-00-008-drop-message-storage-retry-state",
+			SQL: `
+				alter table message_storage
+					drop column if exists attempts,
+					drop column if exists last_error,
+					drop column if exists first_failed_at;
+			`,
+		},
 	}, nil
 }
 
+func messageStorageLockKey(extraKeys ...string) string {
+	sb := strings.Builder{}
+	sb.WriteString(messageStorageLockName)
+	for _, key := range extraKeys {
+		sb.WriteString("_")
+		sb.WriteString(key)
+	}
+
+	return sb.String()
+}
+
+// encodeMessageHeaders returns the jsonb value, it's passed as a string to avoid the bytea encoding
+func encodeMessageHeaders(headers map[string]string) (string, error) {
+	if len(headers) == 0 {
+		return "{}", nil
+	}
+
+	data, err := json.Marshal(headers)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
 type sqlxMessage struct {
-	ID      uuid.UUID `db:"id"`
-	Topic   string    `db:"topic"`
-	Key     string    `db:"key"`
-	Payload []byte    `db:"payload"`
+	ID            uuid.UUID  `db:"id"`
+	Topic         string     `db:"topic"`
+	Key           string     `db:"key"`
+	Payload       []byte     `db:"payload"`
+	Headers       []byte     `db:"headers"`
+	ScheduledAt   time.Time  `db:"scheduled_at"`
+	Attempts      int        `db:"attempts"`
+	LastError     *string    `db:"last_error"`
+	FirstFailedAt *time.Time `db:"first_failed_at"`
+}
+
+type sqlxTopicStats struct {
+	Topic                    string     `db:"topic"`
+	Subscriber               string     `db:"subscriber"`
+	Pending                  int        `db:"pending"`
+	OldestPendingScheduledAt *time.Time `db:"oldest_pending_scheduled_at"`
+	Scheduled                int        `db:"scheduled"`
 }
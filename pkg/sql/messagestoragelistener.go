@@ -0,0 +1,105 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/klwxsrx/go-service-template/pkg/log"
+	"github.com/klwxsrx/go-service-template/pkg/message"
+	"github.com/klwxsrx/go-service-template/pkg/worker"
+)
+
+const (
+	MessageStorageNotificationChannel = "message_storage"
+
+	messageStorageListenerMinReconnectInterval = time.Second
+	messageStorageListenerMaxReconnectInterval = time.Minute
+	messageStorageListenerPingInterval         = 90 * time.Second
+)
+
+// MessageStorageNotificationHandler is called for the topic of the stored message,
+// blank topic means that notifications could be lost and all topics must be processed
+type MessageStorageNotificationHandler func(message.Topic)
+
+// NewMessageStorageListener returns the job listening to MessageStorage notifications
+// sent after the transaction with stored messages is committed
+func NewMessageStorageListener(
+	dsn *DSN,
+	logger log.Logger,
+	handlers ...MessageStorageNotificationHandler,
+) worker.ContextJob {
+	notifyAll := func(topic message.Topic) {
+		for _, handler := range handlers {
+			handler(topic)
+		}
+	}
+
+	return func(ctx context.Context) error {
+		listener := pq.NewListener(
+			dsn.String(),
+			messageStorageListenerMinReconnectInterval,
+			messageStorageListenerMaxReconnectInterval,
+			func(event pq.ListenerEventType, err error) {
+				if err != nil {
+					logger.WithError(err).Warn(ctx, "message storage listener connection event")
+				}
+				if event == pq.ListenerEventReconnected {
+					notifyAll("")
+				}
+			},
+		)
+		defer func() {
+			_ = listener.Close()
+		}()
+
+		err := listener.Listen(MessageStorageNotificationChannel)
+		if err != nil {
+			return fmt.Errorf("listen to %s: %w", MessageStorageNotificationChannel, err)
+		}
+
+		pingTicker := time.NewTicker(messageStorageListenerPingInterval)
+		defer pingTicker.Stop()
+
+		for {
+			select {
+			case notification := <-listener.Notify:
+				if notification == nil {
+					notifyAll("")
+					continue
+				}
+
+				notifyAll(message.Topic(notification.Extra))
+			case <-pingTicker.C:
+				go func() {
+					_ = listener.Ping()
+				}()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func notifyMessageStorage(ctx context.Context, db Client, scheduledAt time.Time, msgs []message.Message) error {
+	if scheduledAt.After(time.Now()) {
+		return nil
+	}
+
+	notified := make(map[message.Topic]struct{}, len(msgs))
+	for _, msg := range msgs {
+		if _, ok := notified[msg.Topic]; ok {
+			continue
+		}
+		notified[msg.Topic] = struct{}{}
+
+		_, err := db.ExecContext(ctx, "select pg_notify($1, $2)", MessageStorageNotificationChannel, string(msg.Topic))
+		if err != nil {
+			return fmt.Errorf("notify topic %s: %w", msg.Topic, err)
+		}
+	}
+
+	return nil
+}
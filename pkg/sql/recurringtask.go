@@ -0,0 +1,83 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/klwxsrx/go-service-template/pkg/message"
+)
+
+type RecurringTaskStorage struct {
+	db Client
+}
+
+func NewRecurringTaskStorage(db Client) message.RecurringTaskStorage {
+	return RecurringTaskStorage{db: db}
+}
+
+func (s RecurringTaskStorage) FindNextRun(ctx context.Context, name, schedule string) (*time.Time, error) {
+	query, args, err := sq.
+		Select("next_run_at").
+		From("message_recurring_task").
+		Where(sq.Eq{"name": name}).
+		Where(sq.Eq{"schedule": schedule}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build query: %w", err)
+	}
+
+	var nextRun time.Time
+	err = s.db.GetContext(ctx, &nextRun, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("select next run: %w", err)
+	}
+
+	return &nextRun, nil
+}
+
+func (s RecurringTaskStorage) SaveNextRun(ctx context.Context, name, schedule string, nextRun time.Time) error {
+	query, args, err := sq.
+		Insert("message_recurring_task").
+		Columns("name", "schedule", "next_run_at").
+		Values(name, schedule, nextRun).
+		Suffix("on conflict (name) do update set schedule = excluded.schedule, next_run_at = excluded.next_run_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("build query: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("save next run: %w", err)
+	}
+
+	return nil
+}
+
+func RecurringTaskMigrations() ([]Migration, error) {
+	return []Migration{
+		{
+			ID: "0000-00-00-001-create-message-recurring-task-table",
+			SQL: `
+				create table if not exists message_recurring_task (
+					name        text        not null primary key,
+					next_run_at timestamptz not null
+				);
+			`,
+		},
+		{
+			ID: "0000-00-00-002-add-message-recurring-task-schedule",
+			SQL: `
+				alter table message_recurring_task add column if not exists schedule text not null default '';
+			`,
+		},
+	}, nil
+}
@@ -2,21 +2,36 @@ package task
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+var ErrNotFound = errors.New("task not found")
+
 type (
 	Task interface {
 		ID() uuid.UUID
 		Type() string
 	}
 
+	// Deduplicated task replaces the scheduled task of the same type with the same DeduplicationKey
+	// instead of being scheduled twice
+	Deduplicated interface {
+		Task
+		DeduplicationKey() string
+	}
+
 	Scheduler interface {
 		Schedule(ctx context.Context, at time.Time, tasks ...Task) error
+		// Cancel removes the scheduled task, returns ErrNotFound if the task isn't scheduled or has already been handled
+		Cancel(ctx context.Context, id uuid.UUID) error
+		// Reschedule moves the scheduled task to the specified time, returns ErrNotFound like Cancel
+		Reschedule(ctx context.Context, id uuid.UUID, at time.Time) error
 	}
 
-	TypedHandler[T Task] func(ctx context.Context, task T) error
-	Handler              TypedHandler[Task]
+	TypedHandler[T Task]      func(ctx context.Context, task T) error
+	TypedBatchHandler[T Task] func(ctx context.Context, tasks []T) error
+	Handler                   TypedHandler[Task]
 )
@@ -0,0 +1,21 @@
+package worker
+
+import "context"
+
+type shutdownContextKey struct{}
+
+// WithShutdownContext attaches the context, that is alive while the job is shutting down after the ctx cancellation
+func WithShutdownContext(ctx, shutdownCtx context.Context) context.Context {
+	return context.WithValue(ctx, shutdownContextKey{}, shutdownCtx)
+}
+
+// ShutdownContext returns the context the job could use to finish its work after the ctx is canceled.
+// Returns the ctx itself if there is no shutdown context, so the job should stop immediately
+func ShutdownContext(ctx context.Context) context.Context {
+	shutdownCtx, ok := ctx.Value(shutdownContextKey{}).(context.Context)
+	if !ok {
+		return ctx
+	}
+
+	return shutdownCtx
+}